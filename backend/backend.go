@@ -0,0 +1,106 @@
+// Package backend selects the anyvec implementation which
+// the commands use for computation.
+//
+// Commands call AddFlags before flag.Parse and Setup after
+// it, so that every command accepts the same -backend and
+// -threads flags.
+package backend
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/unixpickle/anyvec"
+	"github.com/unixpickle/anyvec/anyvec32"
+	"github.com/unixpickle/essentials"
+)
+
+// A Backend creates an anyvec.Creator.
+type Backend struct {
+	Name string
+
+	// Make creates the creator, which should use at most
+	// the given number of threads.
+	// It is only called if the backend is selected.
+	Make func(threads int) (anyvec.Creator, error)
+}
+
+var backends = map[string]*Backend{}
+
+var (
+	flagName    string
+	flagThreads int
+)
+
+// Register adds a backend to the list of available
+// backends.
+func Register(b *Backend) {
+	backends[b.Name] = b
+}
+
+// Names lists the available backends.
+func Names() []string {
+	var res []string
+	for name := range backends {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Default gets the name of the default backend.
+// It is "cuda" when built with CUDA support, or "cpu"
+// otherwise.
+func Default() string {
+	if _, ok := backends["cuda"]; ok {
+		return "cuda"
+	}
+	return "cpu"
+}
+
+// AddFlags registers the -backend and -threads flags with
+// the flag package.
+func AddFlags() {
+	flag.StringVar(&flagName, "backend", Default(),
+		"compute backend ("+strings.Join(Names(), ", ")+")")
+	flag.IntVar(&flagThreads, "threads", runtime.NumCPU(), "maximum CPU threads to use")
+}
+
+// Threads gets the thread count from the -threads flag.
+func Threads() int {
+	return flagThreads
+}
+
+// Setup uses the backend selected by the flags.
+// It returns a description of the backend, suitable for
+// logging.
+func Setup() (string, error) {
+	return Use(flagName, flagThreads)
+}
+
+// Use makes a backend the global anyvec32 creator and
+// limits the process to the given number of threads (by
+// setting GOMAXPROCS).
+//
+// The CPU backend splits matrix multiplications, which
+// dominate convolutions, across all of the threads.
+func Use(name string, threads int) (string, error) {
+	b, ok := backends[name]
+	if !ok {
+		return "", errors.New("unknown backend: " + name)
+	}
+	if threads < 1 {
+		return "", errors.New("thread count must be positive")
+	}
+	runtime.GOMAXPROCS(threads)
+	creator, err := b.Make(threads)
+	if err != nil {
+		return "", essentials.AddCtx("use backend "+name, err)
+	}
+	anyvec32.Use(creator)
+	return fmt.Sprintf("%s backend with %d threads", name, threads), nil
+}
@@ -0,0 +1,83 @@
+package backend
+
+import (
+	"sync"
+
+	"github.com/gonum/blas"
+	"github.com/gonum/blas/blas32"
+	"github.com/gonum/blas/native"
+	"github.com/unixpickle/anyvec"
+	"github.com/unixpickle/anyvec/anyvec32"
+)
+
+// minRowsPerThread is the smallest number of output rows
+// worth giving to a thread.
+// Smaller products are not worth the overhead of extra
+// goroutines.
+const minRowsPerThread = 16
+
+func init() {
+	Register(&Backend{
+		Name: "cpu",
+		Make: makeCPU,
+	})
+}
+
+// makeCPU creates the CPU backend.
+//
+// anyvec32 performs matrix multiplications (including the
+// ones behind convolutions) through the blas32 package, so
+// the backend installs a BLAS implementation which splits
+// each multiplication across the threads.
+func makeCPU(threads int) (anyvec.Creator, error) {
+	blas32.Use(parallelBLAS{Threads: threads})
+	return anyvec32.CurrentCreator(), nil
+}
+
+// parallelBLAS is a blas.Float32 which splits the rows of
+// each matrix multiplication between Threads goroutines.
+//
+// All other routines are the same as the native
+// implementation.
+type parallelBLAS struct {
+	native.Implementation
+	Threads int
+}
+
+// Sgemm computes C = alpha*op(A)*op(B) + beta*C, where C
+// is m by n.
+//
+// Each goroutine computes a contiguous block of the rows
+// of C, so no two goroutines write to the same memory.
+func (p parallelBLAS) Sgemm(tA, tB blas.Transpose, m, n, k int, alpha float32,
+	a []float32, lda int, b []float32, ldb int, beta float32, c []float32, ldc int) {
+	numThreads := p.Threads
+	if max := m / minRowsPerThread; max < numThreads {
+		numThreads = max
+	}
+	if numThreads <= 1 || n == 0 || k == 0 {
+		p.Implementation.Sgemm(tA, tB, m, n, k, alpha, a, lda, b, ldb, beta, c, ldc)
+		return
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < numThreads; i++ {
+		start := i * m / numThreads
+		end := (i + 1) * m / numThreads
+		var subA []float32
+		if tA == blas.NoTrans {
+			subA = a[start*lda:]
+		} else {
+			// A is stored as a k by m matrix, so rows of
+			// op(A) are columns of A.
+			subA = a[start:]
+		}
+		subC := c[start*ldc:]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.Implementation.Sgemm(tA, tB, end-start, n, k, alpha, subA, lda, b, ldb, beta,
+				subC, ldc)
+		}()
+	}
+	wg.Wait()
+}
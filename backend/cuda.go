@@ -0,0 +1,22 @@
+//go:build cuda
+// +build cuda
+
+package backend
+
+import (
+	"github.com/unixpickle/anyvec"
+	"github.com/unixpickle/cudavec"
+)
+
+func init() {
+	Register(&Backend{
+		Name: "cuda",
+		Make: func(threads int) (anyvec.Creator, error) {
+			handle, err := cudavec.NewHandleDefault()
+			if err != nil {
+				return nil, err
+			}
+			return &cudavec.Creator32{Handle: handle}, nil
+		},
+	})
+}
@@ -3,6 +3,7 @@ package imagenet
 import (
 	"encoding/json"
 	"errors"
+	"image"
 	"sort"
 
 	"github.com/unixpickle/anydiff"
@@ -31,6 +32,11 @@ type Classifier struct {
 	// identifier for it.
 	// This may be a word, a WordNet ID, or something else.
 	Classes []string
+
+	// ConfigHash identifies the training configuration
+	// which produced the classifier.
+	// It is empty if the configuration is unknown.
+	ConfigHash string
 }
 
 // DeserializeClassifier deserializes a Classifier.
@@ -45,10 +51,11 @@ func DeserializeClassifier(d []byte) (*Classifier, error) {
 		return nil, errors.New("deserialize classifier: " + err.Error())
 	}
 	return &Classifier{
-		InWidth:  cs.InWidth,
-		InHeight: cs.InHeight,
-		Net:      net,
-		Classes:  cs.Classes,
+		InWidth:    cs.InWidth,
+		InHeight:   cs.InHeight,
+		Net:        net,
+		Classes:    cs.Classes,
+		ConfigHash: cs.ConfigHash,
 	}, nil
 }
 
@@ -78,6 +85,31 @@ func (c *Classifier) Classify(versions []anyvec.Vector) ([]string, []float64) {
 	return sorter.Classes, probs64
 }
 
+// TestingImages produces tensors for different crops of
+// an image, sized for the classifier's input.
+func (c *Classifier) TestingImages(path string) ([]anyvec.Vector, error) {
+	return testingImages(path, c.InWidth, c.InHeight)
+}
+
+// TestingCenterImage crops the center of an image and
+// returns it as a tensor sized for the classifier's input.
+func (c *Classifier) TestingCenterImage(path string) (anyvec.Vector, error) {
+	return testingCenterImage(path, c.InWidth, c.InHeight)
+}
+
+// ImageToTensor converts an image to a tensor sized for
+// the classifier's input, like the package-level
+// ImageToTensor.
+func (c *Classifier) ImageToTensor(img image.Image) anyvec.Vector {
+	return imageToTensor(img, c.InWidth, c.InHeight)
+}
+
+// TensorToImage converts an input tensor of the classifier
+// to an image.
+func (c *Classifier) TensorToImage(tensor anyvec.Vector) image.Image {
+	return tensorToImage(tensor, c.InWidth, c.InHeight)
+}
+
 // SerializerType returns the unique ID used to serialize
 // a Classifier with the serializer package.
 func (c *Classifier) SerializerType() string {
@@ -87,9 +119,10 @@ func (c *Classifier) SerializerType() string {
 // Serialize serializes the Classifier.
 func (c *Classifier) Serialize() ([]byte, error) {
 	meta := &classifierSaver{
-		InWidth:  c.InWidth,
-		InHeight: c.InHeight,
-		Classes:  c.Classes,
+		InWidth:    c.InWidth,
+		InHeight:   c.InHeight,
+		Classes:    c.Classes,
+		ConfigHash: c.ConfigHash,
 	}
 	metaData, err := json.Marshal(meta)
 	if err != nil {
@@ -99,9 +132,10 @@ func (c *Classifier) Serialize() ([]byte, error) {
 }
 
 type classifierSaver struct {
-	InWidth  int
-	InHeight int
-	Classes  []string
+	InWidth    int
+	InHeight   int
+	Classes    []string
+	ConfigHash string `json:",omitempty"`
 }
 
 type probSorter struct {
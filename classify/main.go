@@ -3,10 +3,12 @@ package main
 import (
 	"flag"
 	"fmt"
+	"log"
 
 	"github.com/unixpickle/anyvec"
 	"github.com/unixpickle/essentials"
 	"github.com/unixpickle/imagenet"
+	"github.com/unixpickle/imagenet/backend"
 	"github.com/unixpickle/serializer"
 )
 
@@ -16,32 +18,47 @@ func main() {
 	var numGuesses int
 	var printConfidence bool
 	var centerOnly bool
+	var size int
 	flag.StringVar(&classifierPath, "classifier", "", "path to classifier")
 	flag.StringVar(&imagePath, "image", "", "input image")
 	flag.IntVar(&numGuesses, "n", 1, "number of guesses")
 	flag.BoolVar(&printConfidence, "confidence", false, "print confidence")
 	flag.BoolVar(&centerOnly, "center", false, "only use center crop")
+	flag.IntVar(&size, "size", 0, "input resolution (default: the classifier's own)")
+	backend.AddFlags()
 	flag.Parse()
 
 	if classifierPath == "" || imagePath == "" {
 		essentials.Die("Required flags: -classifier and -image. See -help")
 	}
+	desc, err := backend.Setup()
+	if err != nil {
+		essentials.Die(err)
+	}
+	log.Println("Using", desc)
 
 	var classifier *imagenet.Classifier
 	if err := serializer.LoadAny(classifierPath, &classifier); err != nil {
 		essentials.Die(err)
 	}
+	if size != 0 {
+		var err error
+		classifier, err = classifier.Resize(size, size)
+		if err != nil {
+			essentials.Die(err)
+		}
+	}
 
 	var images []anyvec.Vector
 	if centerOnly {
-		image, err := imagenet.TestingCenterImage(imagePath)
+		image, err := classifier.TestingCenterImage(imagePath)
 		if err != nil {
 			essentials.Die(err)
 		}
 		images = []anyvec.Vector{image}
 	} else {
 		var err error
-		images, err = imagenet.TestingImages(imagePath)
+		images, err = classifier.TestingImages(imagePath)
 		if err != nil {
 			essentials.Die(err)
 		}
@@ -0,0 +1,188 @@
+package imagenet
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+
+	"github.com/unixpickle/anydiff"
+	"github.com/unixpickle/anyvec"
+	"github.com/unixpickle/anyvec/anyvecsave"
+	"github.com/unixpickle/serializer"
+)
+
+func init() {
+	var d DepthwiseConv
+	serializer.RegisterTypedDeserializer(d.SerializerType(), DeserializeDepthwiseConv)
+}
+
+// DepthwiseConv is a convolutional layer which filters
+// every input channel separately, producing one output
+// channel per input channel.
+//
+// A depthwise separable convolution, as used in MobileNets
+// (https://arxiv.org/abs/1704.04861), is a DepthwiseConv
+// followed by a 1x1 anyconv.Conv.
+type DepthwiseConv struct {
+	FilterWidth  int
+	FilterHeight int
+	StrideX      int
+	StrideY      int
+
+	InputWidth  int
+	InputHeight int
+	InputDepth  int
+
+	// Filters stores the filters as a tensor of shape
+	// [FilterHeight][FilterWidth][InputDepth].
+	Filters *anydiff.Var
+
+	// Biases stores one bias per channel.
+	Biases *anydiff.Var
+
+	lock         sync.Mutex
+	im2col       anyvec.Mapper
+	filterMapper anyvec.Mapper
+}
+
+// NewDepthwiseConv creates a DepthwiseConv with randomly
+// initialized filters and zero biases.
+func NewDepthwiseConv(c anyvec.Creator, inWidth, inHeight, inDepth, filterWidth,
+	filterHeight, strideX, strideY int) *DepthwiseConv {
+	filters := make([]float64, filterWidth*filterHeight*inDepth)
+	stddev := math.Sqrt(2 / float64(filterWidth*filterHeight))
+	for i := range filters {
+		filters[i] = rand.NormFloat64() * stddev
+	}
+	return &DepthwiseConv{
+		FilterWidth:  filterWidth,
+		FilterHeight: filterHeight,
+		StrideX:      strideX,
+		StrideY:      strideY,
+		InputWidth:   inWidth,
+		InputHeight:  inHeight,
+		InputDepth:   inDepth,
+		Filters:      anydiff.NewVar(c.MakeVectorData(c.MakeNumericList(filters))),
+		Biases:       anydiff.NewVar(c.MakeVector(inDepth)),
+	}
+}
+
+// DeserializeDepthwiseConv deserializes a DepthwiseConv.
+func DeserializeDepthwiseConv(d []byte) (*DepthwiseConv, error) {
+	var fw, fh, sx, sy, w, h, depth serializer.Int
+	var filters, biases *anyvecsave.S
+	err := serializer.DeserializeAny(d, &fw, &fh, &sx, &sy, &w, &h, &depth, &filters,
+		&biases)
+	if err != nil {
+		return nil, errors.New("deserialize DepthwiseConv: " + err.Error())
+	}
+	return &DepthwiseConv{
+		FilterWidth:  int(fw),
+		FilterHeight: int(fh),
+		StrideX:      int(sx),
+		StrideY:      int(sy),
+		InputWidth:   int(w),
+		InputHeight:  int(h),
+		InputDepth:   int(depth),
+		Filters:      anydiff.NewVar(filters.Vector),
+		Biases:       anydiff.NewVar(biases.Vector),
+	}, nil
+}
+
+// OutputWidth returns the width of the output tensor.
+func (d *DepthwiseConv) OutputWidth() int {
+	return 1 + (d.InputWidth-d.FilterWidth)/d.StrideX
+}
+
+// OutputHeight returns the height of the output tensor.
+func (d *DepthwiseConv) OutputHeight() int {
+	return 1 + (d.InputHeight-d.FilterHeight)/d.StrideY
+}
+
+// Apply applies the layer to a batch of input tensors.
+//
+// For each input tensor, the inputs under every filter
+// position are gathered into a matrix with one row per
+// filter position.
+// The matrix is scaled by the filters and its rows are
+// summed.
+func (d *DepthwiseConv) Apply(in anydiff.Res, n int) anydiff.Res {
+	inSize := d.InputWidth * d.InputHeight * d.InputDepth
+	if in.Output().Len() != n*inSize {
+		panic("incorrect input size")
+	}
+	im2col, filterMapper := d.layerMappers(in.Output().Creator())
+	filters := anydiff.Map(filterMapper, d.Filters)
+	outSize := d.OutputWidth() * d.OutputHeight() * d.InputDepth
+	var outs []anydiff.Res
+	for i := 0; i < n; i++ {
+		sample := anydiff.Slice(in, i*inSize, (i+1)*inSize)
+		products := anydiff.Mul(anydiff.Map(im2col, sample), filters)
+		outs = append(outs, anydiff.SumRows(&anydiff.Matrix{
+			Data: products,
+			Rows: d.FilterWidth * d.FilterHeight,
+			Cols: outSize,
+		}))
+	}
+	return anydiff.AddRepeated(anydiff.Concat(outs...), d.Biases)
+}
+
+// Parameters returns the filters and biases.
+func (d *DepthwiseConv) Parameters() []*anydiff.Var {
+	return []*anydiff.Var{d.Filters, d.Biases}
+}
+
+// SerializerType returns the unique ID used to serialize
+// a DepthwiseConv with the serializer package.
+func (d *DepthwiseConv) SerializerType() string {
+	return "github.com/unixpickle/imagenet.DepthwiseConv"
+}
+
+// Serialize serializes the DepthwiseConv.
+func (d *DepthwiseConv) Serialize() ([]byte, error) {
+	return serializer.SerializeAny(
+		serializer.Int(d.FilterWidth),
+		serializer.Int(d.FilterHeight),
+		serializer.Int(d.StrideX),
+		serializer.Int(d.StrideY),
+		serializer.Int(d.InputWidth),
+		serializer.Int(d.InputHeight),
+		serializer.Int(d.InputDepth),
+		&anyvecsave.S{Vector: d.Filters.Vector},
+		&anyvecsave.S{Vector: d.Biases.Vector},
+	)
+}
+
+// layerMappers gets a mapper which gathers the inputs
+// under each filter position, and a mapper which repeats
+// each filter position's weights for every output.
+func (d *DepthwiseConv) layerMappers(c anyvec.Creator) (im2col, filters anyvec.Mapper) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.im2col != nil {
+		return d.im2col, d.filterMapper
+	}
+	outWidth, outHeight := d.OutputWidth(), d.OutputHeight()
+	var inTable, filterTable []int
+	for fy := 0; fy < d.FilterHeight; fy++ {
+		for fx := 0; fx < d.FilterWidth; fx++ {
+			filterStart := (fy*d.FilterWidth + fx) * d.InputDepth
+			for y := 0; y < outHeight; y++ {
+				inY := y*d.StrideY + fy
+				for x := 0; x < outWidth; x++ {
+					inX := x*d.StrideX + fx
+					inStart := (inY*d.InputWidth + inX) * d.InputDepth
+					for z := 0; z < d.InputDepth; z++ {
+						inTable = append(inTable, inStart+z)
+						filterTable = append(filterTable, filterStart+z)
+					}
+				}
+			}
+		}
+	}
+	inSize := d.InputWidth * d.InputHeight * d.InputDepth
+	d.im2col = c.MakeMapper(inSize, inTable)
+	d.filterMapper = c.MakeMapper(d.Filters.Vector.Len(), filterTable)
+	return d.im2col, d.filterMapper
+}
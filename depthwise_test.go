@@ -0,0 +1,53 @@
+package imagenet
+
+import (
+	"math"
+	"testing"
+
+	"github.com/unixpickle/anydiff"
+	"github.com/unixpickle/anyvec/anyvec32"
+)
+
+func TestDepthwiseConv(t *testing.T) {
+	c := anyvec32.CurrentCreator()
+	layer := NewDepthwiseConv(c, 3, 3, 2, 2, 2, 1, 1)
+	layer.Filters.Vector.SetData([]float32{1, -1, 2, 0.5, 0, 3, -2, 1})
+	layer.Biases.Vector.SetData([]float32{0.5, -0.5})
+
+	// Two 3x3x2 inputs, stored as [y][x][channel].
+	input := make([]float32, 36)
+	for i := range input {
+		input[i] = float32(i%7) - 3
+	}
+	out := layer.Apply(anydiff.NewConst(c.MakeVectorData(input)), 2).Output()
+	actual := out.Data().([]float32)
+
+	filters := layer.Filters.Vector.Data().([]float32)
+	biases := layer.Biases.Vector.Data().([]float32)
+	var expected []float32
+	for n := 0; n < 2; n++ {
+		for y := 0; y < 2; y++ {
+			for x := 0; x < 2; x++ {
+				for z := 0; z < 2; z++ {
+					sum := biases[z]
+					for fy := 0; fy < 2; fy++ {
+						for fx := 0; fx < 2; fx++ {
+							in := input[n*18+((y+fy)*3+x+fx)*2+z]
+							sum += in * filters[(fy*2+fx)*2+z]
+						}
+					}
+					expected = append(expected, sum)
+				}
+			}
+		}
+	}
+
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d outputs but got %d", len(expected), len(actual))
+	}
+	for i, x := range expected {
+		if math.Abs(float64(x-actual[i])) > 1e-4 {
+			t.Errorf("output %d: expected %f but got %f", i, x, actual[i])
+		}
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/unixpickle/anyvec/anyvec32"
 	"github.com/unixpickle/essentials"
 	"github.com/unixpickle/imagenet"
+	"github.com/unixpickle/imagenet/backend"
 	"github.com/unixpickle/rip"
 	"github.com/unixpickle/serializer"
 )
@@ -30,11 +31,17 @@ func main() {
 	flag.StringVar(&outPath, "out", "output.png", "output image path")
 	flag.Float64Var(&stepSize, "step", 10, "SGD step size")
 	flag.IntVar(&layer, "layer", 21, "layer to maximize")
+	backend.AddFlags()
 
 	flag.Parse()
 	if imagePath == "" || netPath == "" {
 		essentials.Die("Required flags: -in and -net. See -help.")
 	}
+	desc, err := backend.Setup()
+	if err != nil {
+		essentials.Die(err)
+	}
+	log.Println("Using", desc)
 
 	var net *imagenet.Classifier
 	if err := serializer.LoadAny(netPath, &net); err != nil {
@@ -45,7 +52,7 @@ func main() {
 	}
 	preLayers := net.Net[:layer]
 
-	tensor, err := imagenet.TestingCenterImage(imagePath)
+	tensor, err := net.TestingCenterImage(imagePath)
 	if err != nil {
 		essentials.Die(err)
 	}
@@ -69,7 +76,7 @@ func main() {
 	}
 
 	log.Println("Saving output image...")
-	image := imagenet.TensorToImage(anydiff.Sigmoid(params).Output())
+	image := net.TensorToImage(anydiff.Sigmoid(params).Output())
 	w, err := os.Create(outPath)
 	if err != nil {
 		essentials.Die(err)
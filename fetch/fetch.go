@@ -16,32 +16,40 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+
+	"github.com/unixpickle/imagenet"
 )
 
 const FetchRoutines = 30
 const ImageNetAPI = "http://www.image-net.org/api/text/imagenet.synset.geturls?wnid="
 
-func Fetch(wnids []string, imgCount int, outDir string) {
-	wnidChan := make(chan string, len(wnids))
-	for _, w := range wnids {
-		wnidChan <- w
+// Fetch downloads up to imgCount images for each wnid.
+//
+// The order in which each wnid's URLs are tried is derived
+// from the seed and the wnid's index, so it does not
+// depend on the order in which wnids are fetched.
+func Fetch(wnids []string, imgCount int, outDir string, seed int64) {
+	indices := make(chan int, len(wnids))
+	for i := range wnids {
+		indices <- i
 	}
-	close(wnidChan)
+	close(indices)
 
 	var wg sync.WaitGroup
 	for i := 0; i < FetchRoutines; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			for wnid := range wnidChan {
-				fetchWNID(wnid, imgCount, outDir)
+			for idx := range indices {
+				gen := rand.New(rand.NewSource(imagenet.MixSeed(seed, int64(idx))))
+				fetchWNID(wnids[idx], imgCount, outDir, gen)
 			}
 		}()
 	}
 	wg.Wait()
 }
 
-func fetchWNID(wnid string, maxCount int, outDir string) {
+func fetchWNID(wnid string, maxCount int, outDir string, gen *rand.Rand) {
 	log.Println("Fetching images for wnid:", wnid)
 	defer log.Println("Done with", wnid)
 
@@ -84,7 +92,7 @@ func fetchWNID(wnid string, maxCount int, outDir string) {
 		return
 	}
 
-	for _, i := range rand.Perm(len(urls)) {
+	for _, i := range gen.Perm(len(urls)) {
 		if imageCount >= maxCount {
 			break
 		}
@@ -1,9 +1,10 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"io/ioutil"
-	"math/rand"
+	"log"
 	"os"
 	"strconv"
 	"strings"
@@ -11,36 +12,41 @@ import (
 )
 
 const (
-	WnidFileArg = 1
-	ImgCountArg = 2
-	DirOutArg   = 3
+	WnidFileArg = 0
+	ImgCountArg = 1
+	DirOutArg   = 2
 )
 
 func main() {
-	rand.Seed(time.Now().UnixNano())
+	var seed int64
+	flag.Int64Var(&seed, "seed", 0, "random seed for URL order (default: based on time)")
+	flag.Parse()
 
-	if len(os.Args) != 4 {
-		fmt.Fprintln(os.Stderr, "Usage:", os.Args[0], "wnids img_count dir_out")
+	args := flag.Args()
+	if len(args) != 3 {
+		fmt.Fprintln(os.Stderr, "Usage:", os.Args[0], "[flags] wnids img_count dir_out")
 		fmt.Fprintln(os.Stderr, "  wnids      file with space-separated wnids")
 		fmt.Fprintln(os.Stderr, "  img_count  number of images per wnid")
 		fmt.Fprintln(os.Stderr, "  dir_out    output directory")
+		fmt.Fprintln(os.Stderr)
+		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
-	imgCount, err := strconv.Atoi(os.Args[ImgCountArg])
+	imgCount, err := strconv.Atoi(args[ImgCountArg])
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Invalid image count:", os.Args[ImgCountArg])
+		fmt.Fprintln(os.Stderr, "Invalid image count:", args[ImgCountArg])
 		os.Exit(1)
 	}
 
-	wnidData, err := ioutil.ReadFile(os.Args[WnidFileArg])
+	wnidData, err := ioutil.ReadFile(args[WnidFileArg])
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Failed to read wnids:", err)
 		os.Exit(1)
 	}
 	wnids := strings.Fields(string(wnidData))
 
-	outDir := os.Args[DirOutArg]
+	outDir := args[DirOutArg]
 	if statRes, err := os.Stat(outDir); err != nil && os.IsNotExist(err) {
 		if err := os.Mkdir(outDir, 0755); err != nil {
 			fmt.Fprintln(os.Stderr, "Failed to create output:", err)
@@ -54,5 +60,10 @@ func main() {
 		os.Exit(1)
 	}
 
-	Fetch(wnids, imgCount, outDir)
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	log.Println("Using random seed", seed)
+
+	Fetch(wnids, imgCount, outDir, seed)
 }
@@ -0,0 +1,282 @@
+package imagenet
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"sync"
+
+	"github.com/unixpickle/anydiff"
+	"github.com/unixpickle/anynet/anyff"
+	"github.com/unixpickle/anynet/anysgd"
+	"github.com/unixpickle/anyvec"
+)
+
+// maxSubstituteAttempts is the number of random samples
+// tried before giving up on replacing a bad sample.
+const maxSubstituteAttempts = 10
+
+// A BadSamplePolicy determines how a Fetcher deals with
+// samples that cannot be loaded.
+type BadSamplePolicy int
+
+const (
+	// FailOnBadSamples causes the fetch to fail.
+	FailOnBadSamples BadSamplePolicy = iota
+
+	// SkipBadSamples drops bad samples from the batch,
+	// making the batch smaller.
+	SkipBadSamples
+
+	// SubstituteBadSamples replaces bad samples with other
+	// randomly chosen samples.
+	SubstituteBadSamples
+)
+
+// ParseBadSamplePolicy parses a policy name, as produced
+// by BadSamplePolicy.String().
+func ParseBadSamplePolicy(name string) (BadSamplePolicy, error) {
+	for _, p := range []BadSamplePolicy{FailOnBadSamples, SkipBadSamples,
+		SubstituteBadSamples} {
+		if p.String() == name {
+			return p, nil
+		}
+	}
+	return 0, errors.New("unknown bad sample policy: " + name)
+}
+
+// String returns the name of the policy.
+func (b BadSamplePolicy) String() string {
+	switch b {
+	case FailOnBadSamples:
+		return "fail"
+	case SkipBadSamples:
+		return "skip"
+	case SubstituteBadSamples:
+		return "substitute"
+	default:
+		return "unknown"
+	}
+}
+
+// A Fetcher is an anysgd.Fetcher for SampleLists which
+// can tolerate unreadable images.
+//
+// It is safe to use a Fetcher from multiple Goroutines.
+type Fetcher struct {
+	Policy BadSamplePolicy
+
+	// Pool is the list from which substitute samples are
+	// drawn.
+	// If it is nil, substitutes are drawn from the list
+	// being fetched.
+	Pool SampleList
+
+	// LogFunc, if non-nil, is called for each bad sample
+	// that is encountered.
+	LogFunc func(s *Sample, err error)
+
+	// Workers is the number of Goroutines used to load the
+	// samples in a batch.
+	// If it is 0, samples are loaded one at a time.
+	Workers int
+
+	// Testing, if set, causes samples to be loaded without
+	// augmentation, as done by GetTestingSample.
+	Testing bool
+
+	// Augmentation, if non-nil, is used for training
+	// samples instead of DefaultAugmentation.
+	Augmentation *Augmentation
+
+	// ImageWidth and ImageHeight are the size of the input
+	// tensors.
+	// If they are 0, InputImageSize is used.
+	ImageWidth  int
+	ImageHeight int
+
+	// Rand, if non-nil, is used to seed the augmentations
+	// and substitutions for every sample.
+	// Fetching the same batches in the same order with an
+	// identically seeded Rand yields identical results,
+	// regardless of Workers.
+	//
+	// If Rand is nil, the global random source is used.
+	Rand *rand.Rand
+
+	lock sync.Mutex
+	bad  map[string]bool
+}
+
+// Fetch loads the samples in the SampleList s and
+// produces an *anyff.Batch.
+func (f *Fetcher) Fetch(s anysgd.SampleList) (anysgd.Batch, error) {
+	f.lock.Lock()
+	seeds := make([]int64, s.Len())
+	for i := range seeds {
+		if f.Rand != nil {
+			seeds[i] = f.Rand.Int63()
+		} else {
+			seeds[i] = rand.Int63()
+		}
+	}
+	f.lock.Unlock()
+	return f.fetchSeeds(s.(SampleList), seeds)
+}
+
+// FetchRand is like Fetch, but it draws its randomness
+// from gen instead of f.Rand.
+func (f *Fetcher) FetchRand(s anysgd.SampleList, gen *rand.Rand) (anysgd.Batch, error) {
+	seeds := make([]int64, s.Len())
+	for i := range seeds {
+		seeds[i] = gen.Int63()
+	}
+	return f.fetchSeeds(s.(SampleList), seeds)
+}
+
+func (f *Fetcher) fetchSeeds(list SampleList, seeds []int64) (anysgd.Batch, error) {
+	samples, err := f.getSamples(list, seeds)
+	if err != nil {
+		return nil, err
+	}
+	var inputs, outputs []anyvec.Vector
+	for _, sample := range samples {
+		if sample == nil {
+			continue
+		}
+		inputs = append(inputs, sample.Input)
+		outputs = append(outputs, sample.Output)
+	}
+	if len(inputs) == 0 {
+		return nil, errors.New("fetch: no readable samples in batch")
+	}
+	c := inputs[0].Creator()
+	return &anyff.Batch{
+		Inputs:  anydiff.NewConst(c.Concat(inputs...)),
+		Outputs: anydiff.NewConst(c.Concat(outputs...)),
+		Num:     len(inputs),
+	}, nil
+}
+
+// BadSamples returns the sorted paths of every bad sample
+// encountered so far.
+func (f *Fetcher) BadSamples() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var res []string
+	for path := range f.bad {
+		res = append(res, path)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// getSamples loads every sample in the list, using
+// f.Workers Goroutines.
+func (f *Fetcher) getSamples(list SampleList, seeds []int64) ([]*anyff.Sample, error) {
+	samples := make([]*anyff.Sample, len(list))
+	errs := make([]error, len(list))
+	workers := f.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	indices := make(chan int, len(list))
+	for i := range list {
+		indices <- i
+	}
+	close(indices)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range indices {
+				gen := rand.New(rand.NewSource(seeds[idx]))
+				samples[idx], errs[idx] = f.getSample(list, idx, gen)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return samples, nil
+}
+
+// getSample loads a sample according to the policy.
+// It returns nil (with no error) for skipped samples.
+func (f *Fetcher) getSample(list SampleList, idx int,
+	gen *rand.Rand) (*anyff.Sample, error) {
+	sample, err := f.tryGetSample(list, idx, gen)
+	if err == nil || f.Policy == FailOnBadSamples {
+		return sample, err
+	} else if f.Policy == SkipBadSamples {
+		return nil, nil
+	}
+
+	pool := f.Pool
+	if pool == nil {
+		pool = list
+	}
+	for i := 0; i < maxSubstituteAttempts; i++ {
+		sample, err = f.tryGetSample(pool, gen.Intn(len(pool)), gen)
+		if err == nil {
+			return sample, nil
+		}
+	}
+	return nil, errors.New("fetch: no substitute for bad sample: " + err.Error())
+}
+
+func (f *Fetcher) tryGetSample(list SampleList, idx int,
+	gen *rand.Rand) (*anyff.Sample, error) {
+	if f.isBad(list[idx].Path) {
+		return nil, errors.New("known bad sample: " + list[idx].Path)
+	}
+	width, height := f.imageSize()
+	var sample *anyff.Sample
+	var err error
+	if f.Testing {
+		sample, err = list.GetSizedTestingSample(idx, width, height)
+	} else if f.Augmentation != nil {
+		sample, err = list.GetSizedSample(idx, f.Augmentation, width, height, gen)
+	} else {
+		sample, err = list.GetSizedSample(idx, &DefaultAugmentation, width, height, gen)
+	}
+	if err != nil {
+		f.markBad(&list[idx], err)
+	}
+	return sample, err
+}
+
+func (f *Fetcher) imageSize() (width, height int) {
+	width, height = f.ImageWidth, f.ImageHeight
+	if width == 0 {
+		width = InputImageSize
+	}
+	if height == 0 {
+		height = InputImageSize
+	}
+	return
+}
+
+func (f *Fetcher) isBad(path string) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.bad[path]
+}
+
+func (f *Fetcher) markBad(s *Sample, err error) {
+	f.lock.Lock()
+	if f.bad == nil {
+		f.bad = map[string]bool{}
+	}
+	f.bad[s.Path] = true
+	f.lock.Unlock()
+	if f.LogFunc != nil {
+		f.LogFunc(s, err)
+	}
+}
@@ -1,6 +1,7 @@
 package imagenet
 
 import (
+	"errors"
 	"image"
 	"image/color"
 	_ "image/jpeg"
@@ -20,23 +21,128 @@ const (
 	MaxAugmentedSize = 480
 )
 
-// TrainingImage loads the image at the given path and
-// transforms it into tensor data.
-// It performs various manipulations to the image for the
-// purpose of data augmentation.
-func TrainingImage(path string) (anyvec.Vector, error) {
+// An Augmentation describes the random manipulations
+// made to training images.
+type Augmentation struct {
+	// MinSize and MaxSize bound the size of the smaller
+	// dimension of the image after it is randomly scaled.
+	MinSize int
+	MaxSize int
+
+	// Mirror enables random horizontal flips.
+	Mirror bool
+
+	// ColorScale scales the random color perturbation.
+	// If it is 0, colors are not perturbed.
+	ColorScale float64
+}
+
+// DefaultAugmentation is the augmentation performed by
+// TrainingImage.
+var DefaultAugmentation = Augmentation{
+	MinSize:    MinAugmentedSize,
+	MaxSize:    MaxAugmentedSize,
+	Mirror:     true,
+	ColorScale: 1,
+}
+
+// Validate checks that the augmentation can produce
+// images of size InputImageSize.
+//
+// Larger images can still be produced, in which case the
+// minimum size is increased as needed.
+func (a *Augmentation) Validate() error {
+	return a.ValidateSize(InputImageSize, InputImageSize)
+}
+
+// ValidateSize is like Validate, but for images of the
+// given width and height.
+func (a *Augmentation) ValidateSize(width, height int) error {
+	if a.MinSize < width || a.MinSize < height {
+		return errors.New("augmentation: minimum size is smaller than input size")
+	} else if a.MaxSize < a.MinSize {
+		return errors.New("augmentation: maximum size is smaller than minimum size")
+	}
+	return nil
+}
+
+// Image loads the image at the given path, augments it,
+// and transforms it into tensor data.
+//
+// The random augmentations are drawn from gen.
+func (a *Augmentation) Image(path string, gen *rand.Rand) (anyvec.Vector, error) {
+	return a.SizedImage(path, InputImageSize, InputImageSize, gen)
+}
+
+// SizedImage is like Image, but produces a tensor with the
+// given width and height rather than InputImageSize.
+func (a *Augmentation) SizedImage(path string, width, height int,
+	gen *rand.Rand) (anyvec.Vector, error) {
 	orig, err := readImage(path)
 	if err != nil {
 		return nil, essentials.AddCtx("read image "+path, err)
 	}
-	img := augmentedImage(orig)
-	colorAugment(img)
+	img := a.augmentedImage(orig, width, height, gen)
+	if a.ColorScale != 0 {
+		colorAugment(img, float32(a.ColorScale), gen)
+	}
 	return anyvec32.MakeVectorData(img), nil
 }
 
+// TrainingImage loads the image at the given path and
+// transforms it into tensor data.
+// It performs various manipulations to the image for the
+// purpose of data augmentation, as described by
+// DefaultAugmentation.
+//
+// The random augmentations are drawn from gen.
+func TrainingImage(path string, gen *rand.Rand) (anyvec.Vector, error) {
+	return DefaultAugmentation.Image(path, gen)
+}
+
 // TestingImages produces tensors for different crops of
 // the image.
+//
+// The tensors are InputImageSize on both sides.
+// Use Classifier.TestingImages for classifiers with other
+// input sizes.
 func TestingImages(path string) ([]anyvec.Vector, error) {
+	return testingImages(path, InputImageSize, InputImageSize)
+}
+
+// TestingCenterImage crops the center of the image and
+// returns it as a tensor.
+//
+// The tensor is InputImageSize on both sides.
+// Use Classifier.TestingCenterImage for classifiers with
+// other input sizes.
+func TestingCenterImage(path string) (anyvec.Vector, error) {
+	return testingCenterImage(path, InputImageSize, InputImageSize)
+}
+
+// ImageToTensor converts an image to a tensor.
+//
+// The image is scaled and cropped (in the center) so that
+// the output tensor has the right dimensions.
+// If the image is already InputImageSize on both sides,
+// then it is not cropped or scaled.
+//
+// Use Classifier.ImageToTensor for classifiers with other
+// input sizes.
+func ImageToTensor(img image.Image) anyvec.Vector {
+	return imageToTensor(img, InputImageSize, InputImageSize)
+}
+
+// TensorToImage converts a tensor of size InputImageSize
+// to an image.
+//
+// Use Classifier.TensorToImage for classifiers with other
+// input sizes.
+func TensorToImage(tensor anyvec.Vector) image.Image {
+	return tensorToImage(tensor, InputImageSize, InputImageSize)
+}
+
+func testingImages(path string, width, height int) ([]anyvec.Vector, error) {
 	img, err := readImage(path)
 	if err != nil {
 		return nil, essentials.AddCtx("read image "+path, err)
@@ -45,24 +151,32 @@ func TestingImages(path string) ([]anyvec.Vector, error) {
 	if img.Bounds().Dy() < smallerDim {
 		smallerDim = img.Bounds().Dy()
 	}
+
+	// The scales are relative to the input size, so that
+	// the crops cover the same fraction of the image for
+	// every input size.
+	inputSize := width
+	if height > inputSize {
+		inputSize = height
+	}
 	var images [][]float32
 	for _, size := range []float64{224, 256, 384, 480, 640} {
-		scale := size / float64(smallerDim)
+		scale := size * float64(inputSize) / (InputImageSize * float64(smallerDim))
 		newImage := resize.Resize(uint(float64(img.Bounds().Dx())*scale+0.5),
 			uint(float64(img.Bounds().Dy())*scale+0.5), img, resize.Bilinear)
+		maxX := newImage.Bounds().Dx() - width
+		maxY := newImage.Bounds().Dy() - height
 		images = append(images,
 			// Top left
-			crop(newImage, 0, 0, false),
+			crop(newImage, 0, 0, width, height, false),
 			// Center
-			crop(newImage, (newImage.Bounds().Dx()-InputImageSize)/2,
-				(newImage.Bounds().Dy()-InputImageSize)/2, false),
+			crop(newImage, maxX/2, maxY/2, width, height, false),
 			// Bottom right
-			crop(newImage, newImage.Bounds().Dx()-InputImageSize,
-				newImage.Bounds().Dy()-InputImageSize, false),
+			crop(newImage, maxX, maxY, width, height, false),
 			// Bottom left
-			crop(newImage, 0, newImage.Bounds().Dy()-InputImageSize, false),
+			crop(newImage, 0, maxY, width, height, false),
 			// Top right
-			crop(newImage, newImage.Bounds().Dx()-InputImageSize, 0, false),
+			crop(newImage, maxX, 0, width, height, false),
 		)
 	}
 	var res []anyvec.Vector
@@ -72,39 +186,26 @@ func TestingImages(path string) ([]anyvec.Vector, error) {
 	return res, nil
 }
 
-// TestingCenterImage crops the center of the image and
-// returns it as a tensor.
-func TestingCenterImage(path string) (anyvec.Vector, error) {
+func testingCenterImage(path string, width, height int) (anyvec.Vector, error) {
 	img, err := readImage(path)
 	if err != nil {
 		return nil, essentials.AddCtx("read image "+path, err)
 	}
-	return ImageToTensor(img), nil
+	return imageToTensor(img, width, height), nil
 }
 
-// ImageToTensor converts an image to a tensor.
-//
-// The image is scaled and cropped (in the center) so that
-// the output tensor has the right dimensions.
-// If the image is already InputImageSize on both sides,
-// then it is not cropped or scaled.
-func ImageToTensor(img image.Image) anyvec.Vector {
-	smallerDim := img.Bounds().Dx()
-	if img.Bounds().Dy() < smallerDim {
-		smallerDim = img.Bounds().Dy()
-	}
-	scale := InputImageSize / float64(smallerDim)
+func imageToTensor(img image.Image, width, height int) anyvec.Vector {
+	scale := coverScale(img, width, height)
 	newImage := resize.Resize(uint(float64(img.Bounds().Dx())*scale+0.5),
 		uint(float64(img.Bounds().Dy())*scale+0.5), img, resize.Bilinear)
-	slice := crop(newImage, (newImage.Bounds().Dx()-InputImageSize)/2,
-		(newImage.Bounds().Dy()-InputImageSize)/2, false)
+	slice := crop(newImage, (newImage.Bounds().Dx()-width)/2,
+		(newImage.Bounds().Dy()-height)/2, width, height, false)
 	return anyvec32.MakeVectorData(slice)
 }
 
-// TensorToImage converts a tensor to an image.
-func TensorToImage(tensor anyvec.Vector) image.Image {
+func tensorToImage(tensor anyvec.Vector, width, height int) image.Image {
 	data := tensor.Data().([]float32)
-	res := image.NewRGBA(image.Rect(0, 0, InputImageSize, InputImageSize))
+	res := image.NewRGBA(image.Rect(0, 0, width, height))
 	for y := 0; y < res.Bounds().Dy(); y++ {
 		for x := 0; x < res.Bounds().Dx(); x++ {
 			idx := 3 * (x + y*res.Bounds().Dx())
@@ -122,6 +223,16 @@ func TensorToImage(tensor anyvec.Vector) image.Image {
 	return res
 }
 
+// coverScale computes the smallest scale factor for which
+// the image covers a width by height rectangle.
+func coverScale(img image.Image, width, height int) float64 {
+	scale := float64(width) / float64(img.Bounds().Dx())
+	if s := float64(height) / float64(img.Bounds().Dy()); s > scale {
+		scale = s
+	}
+	return scale
+}
+
 func readImage(path string) (image.Image, error) {
 	f, err := os.Open(path)
 	if err != nil {
@@ -135,30 +246,35 @@ func readImage(path string) (image.Image, error) {
 	return img, nil
 }
 
-func augmentedImage(img image.Image) []float32 {
+func (a *Augmentation) augmentedImage(img image.Image, width, height int,
+	gen *rand.Rand) []float32 {
 	smallerDim := img.Bounds().Dx()
 	if img.Bounds().Dy() < smallerDim {
 		smallerDim = img.Bounds().Dy()
 	}
 
 	// Scale augmentation
-	newSize := rand.Intn(MaxAugmentedSize-MinAugmentedSize+1) + MinAugmentedSize
+	newSize := gen.Intn(a.MaxSize-a.MinSize+1) + a.MinSize
 	scale := float64(newSize) / float64(smallerDim)
+	if minScale := coverScale(img, width, height); scale < minScale {
+		scale = minScale
+	}
 	newImage := resize.Resize(uint(float64(img.Bounds().Dx())*scale+0.5),
 		uint(float64(img.Bounds().Dy())*scale+0.5), img, resize.Bilinear)
 
-	cropX := rand.Intn(newImage.Bounds().Dx() - InputImageSize + 1)
-	cropY := rand.Intn(newImage.Bounds().Dy() - InputImageSize + 1)
-	return crop(newImage, cropX, cropY, rand.Intn(2) == 1)
+	cropX := gen.Intn(newImage.Bounds().Dx() - width + 1)
+	cropY := gen.Intn(newImage.Bounds().Dy() - height + 1)
+	mirror := a.Mirror && gen.Intn(2) == 1
+	return crop(newImage, cropX, cropY, width, height, mirror)
 }
 
-func crop(img image.Image, cropX, cropY int, mirror bool) []float32 {
-	resSlice := make([]float32, 0, InputImageSize*InputImageSize*3)
-	for y := 0; y < InputImageSize; y++ {
-		for x := 0; x < InputImageSize; x++ {
+func crop(img image.Image, cropX, cropY, width, height int, mirror bool) []float32 {
+	resSlice := make([]float32, 0, width*height*3)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
 			sourceX := x
 			if mirror {
-				sourceX = InputImageSize - (x + 1)
+				sourceX = width - (x + 1)
 			}
 			c := img.At(cropX+sourceX+img.Bounds().Min.X, cropY+y+img.Bounds().Min.Y)
 			r, g, b, _ := c.RGBA()
@@ -169,8 +285,8 @@ func crop(img image.Image, cropX, cropY int, mirror bool) []float32 {
 	return resSlice
 }
 
-func colorAugment(t []float32) {
-	amount := float32(rand.NormFloat64())
+func colorAugment(t []float32, scale float32, gen *rand.Rand) {
+	amount := scale * float32(gen.NormFloat64())
 
 	// Vector from https://groups.google.com/forum/#!topic/lasagne-users/meCDNeA9Ud4.
 	vec := []float32{0.0148366, 0.01253134, 0.01040762}
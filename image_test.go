@@ -4,10 +4,24 @@ import (
 	"image"
 	"image/jpeg"
 	"io/ioutil"
+	"math/rand"
 	"os"
 	"testing"
 )
 
+func TestClassifierImageSize(t *testing.T) {
+	c := &Classifier{InWidth: 96, InHeight: 64}
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	tensor := c.ImageToTensor(img)
+	if tensor.Len() != 96*64*3 {
+		t.Fatalf("expected %d tensor components but got %d", 96*64*3, tensor.Len())
+	}
+	bounds := c.TensorToImage(tensor).Bounds()
+	if bounds.Dx() != 96 || bounds.Dy() != 64 {
+		t.Errorf("unexpected image bounds: %v", bounds)
+	}
+}
+
 func BenchmarkTrainingImage(b *testing.B) {
 	img := image.NewRGBA(image.Rect(0, 0, InputImageSize, InputImageSize))
 	w, err := ioutil.TempFile("", "imagenet_test")
@@ -19,8 +33,9 @@ func BenchmarkTrainingImage(b *testing.B) {
 		b.Fatal(err)
 	}
 
+	gen := rand.New(rand.NewSource(0))
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		TrainingImage(w.Name())
+		TrainingImage(w.Name(), gen)
 	}
 }
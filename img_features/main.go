@@ -6,12 +6,14 @@ package main
 import (
 	"flag"
 	"fmt"
+	"log"
 	"os"
 
 	"github.com/unixpickle/anydiff"
 	"github.com/unixpickle/anyvec"
 	"github.com/unixpickle/essentials"
 	"github.com/unixpickle/imagenet"
+	"github.com/unixpickle/imagenet/backend"
 	"github.com/unixpickle/serializer"
 )
 
@@ -23,11 +25,17 @@ func main() {
 	flag.IntVar(&truncLayers, "layers", 2, "number of output layers to ignore")
 	flag.BoolVar(&centerOnly, "center", false, "only use one (centered) crop")
 	flag.BoolVar(&fiveCrop, "fivecrop", false, "only use five crops")
+	backend.AddFlags()
 	flag.Parse()
 
 	if len(flag.Args()) < 2 {
 		essentials.Die("Usage: img_features [flags] net_file images...")
 	}
+	desc, err := backend.Setup()
+	if err != nil {
+		essentials.Die(err)
+	}
+	log.Println("Using", desc)
 	netPath := flag.Args()[0]
 	imagePaths := flag.Args()[1:]
 
@@ -43,7 +51,7 @@ func main() {
 	for _, path := range imagePaths {
 		var croppings []anyvec.Vector
 		if centerOnly {
-			img, err := imagenet.TestingCenterImage(path)
+			img, err := classifier.TestingCenterImage(path)
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
 				continue
@@ -51,7 +59,7 @@ func main() {
 			croppings = []anyvec.Vector{img}
 		} else {
 			var err error
-			croppings, err = imagenet.TestingImages(path)
+			croppings, err = classifier.TestingImages(path)
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
 				continue
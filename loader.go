@@ -0,0 +1,77 @@
+package imagenet
+
+import (
+	"math/rand"
+
+	"github.com/unixpickle/anynet/anysgd"
+)
+
+// A RandFetcher is an anysgd.Fetcher which can draw its
+// randomness from an explicit source.
+type RandFetcher interface {
+	anysgd.Fetcher
+	FetchRand(s anysgd.SampleList, gen *rand.Rand) (anysgd.Batch, error)
+}
+
+// A LoadedBatch is a batch produced by a Loader.
+type LoadedBatch struct {
+	Batch anysgd.Batch
+	Err   error
+}
+
+// A Loader loads batches of samples in the background so
+// that they are ready by the time they are needed.
+type Loader struct {
+	// Fetcher is used to load each batch.
+	Fetcher RandFetcher
+
+	// Prefetch is the number of batches to load ahead of
+	// time.
+	// If it is 0, one batch is loaded ahead of time.
+	Prefetch int
+}
+
+// Load loads consecutive batches of batchSize samples,
+// starting with the batch at index start.
+// Batches are delivered in order, and a trailing partial
+// batch is dropped.
+//
+// The randomness for each batch is derived from seed and
+// the index of the batch, so the same batch is produced
+// no matter where loading starts.
+//
+// The returned channel is closed once every batch has
+// been delivered or once done is closed.
+// Callers must close done if they stop reading early.
+func (l *Loader) Load(samples SampleList, batchSize int, seed int64, start int,
+	done <-chan struct{}) <-chan *LoadedBatch {
+	prefetch := l.Prefetch
+	if prefetch < 1 {
+		prefetch = 1
+	}
+	res := make(chan *LoadedBatch, prefetch-1)
+	go func() {
+		defer close(res)
+		for i := start * batchSize; i+batchSize <= len(samples); i += batchSize {
+			gen := rand.New(rand.NewSource(MixSeed(seed, int64(i/batchSize))))
+			batch, err := l.Fetcher.FetchRand(samples[i:i+batchSize], gen)
+			select {
+			case res <- &LoadedBatch{Batch: batch, Err: err}:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return res
+}
+
+// MixSeed derives a new random seed from a seed and an
+// index, such that nearby indices yield unrelated seeds.
+func MixSeed(seed, idx int64) int64 {
+	// Based on the SplitMix64 finalizer.
+	x := uint64(seed) + uint64(idx)*0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	x ^= x >> 31
+	return int64(x >> 1)
+}
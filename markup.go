@@ -0,0 +1,111 @@
+package imagenet
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/unixpickle/anynet"
+	"github.com/unixpickle/anynet/anyconv"
+	"github.com/unixpickle/anyvec"
+	"github.com/unixpickle/convmarkup"
+	"github.com/unixpickle/essentials"
+)
+
+// NetFromMarkup creates a network from a markup
+// description, like anyconv.FromMarkup.
+//
+// In addition to the layers supported by anyconv, the
+// markup may contain DepthwiseConv layers, which take the
+// attributes w, h, sx, and sy like Conv layers.
+// See DepthwiseConv.
+func NetFromMarkup(c anyvec.Creator, code string) (anynet.Net, error) {
+	parsed, err := convmarkup.Parse(code)
+	if err != nil {
+		return nil, essentials.AddCtx("net from markup", err)
+	}
+	creators := convmarkup.DefaultCreators()
+	creators["DepthwiseConv"] = createDepthwiseBlock
+	block, err := parsed.Block(convmarkup.Dims{}, creators)
+	if err != nil {
+		return nil, essentials.AddCtx("net from markup", err)
+	}
+	chain := convmarkup.RealizerChain{
+		convmarkup.MetaRealizer{},
+		depthwiseRealizer{Creator: c},
+		anyconv.Realizer(c),
+	}
+	obj, err := chain.Realize(convmarkup.Dims{}, block)
+	if err != nil {
+		return nil, essentials.AddCtx("net from markup", err)
+	}
+	net, ok := obj.(anynet.Net)
+	if !ok {
+		return nil, errors.New("net from markup: markup is not a network")
+	}
+	return net, nil
+}
+
+type depthwiseBlock struct {
+	In           convmarkup.Dims
+	FilterWidth  int
+	FilterHeight int
+	StrideX      int
+	StrideY      int
+}
+
+func createDepthwiseBlock(in convmarkup.Dims, attr map[string]float64,
+	children []convmarkup.Block) (convmarkup.Block, error) {
+	if len(children) != 0 {
+		return nil, errors.New("DepthwiseConv: unexpected children")
+	}
+	res := &depthwiseBlock{In: in, StrideX: 1, StrideY: 1}
+	fields := map[string]*int{
+		"w":  &res.FilterWidth,
+		"h":  &res.FilterHeight,
+		"sx": &res.StrideX,
+		"sy": &res.StrideY,
+	}
+	for name, value := range attr {
+		field, ok := fields[name]
+		if !ok {
+			return nil, errors.New("DepthwiseConv: unknown attribute: " + name)
+		}
+		*field = int(value)
+		if *field < 1 || float64(*field) != value {
+			return nil, fmt.Errorf("DepthwiseConv: invalid %s: %v", name, value)
+		}
+	}
+	if res.FilterWidth == 0 || res.FilterHeight == 0 {
+		return nil, errors.New("DepthwiseConv: missing w or h attribute")
+	}
+	if res.FilterWidth > in.Width || res.FilterHeight > in.Height {
+		return nil, errors.New("DepthwiseConv: filter larger than input")
+	}
+	return res, nil
+}
+
+func (d *depthwiseBlock) Type() string {
+	return "DepthwiseConv"
+}
+
+func (d *depthwiseBlock) OutDims() convmarkup.Dims {
+	return convmarkup.Dims{
+		Width:  1 + (d.In.Width-d.FilterWidth)/d.StrideX,
+		Height: 1 + (d.In.Height-d.FilterHeight)/d.StrideY,
+		Depth:  d.In.Depth,
+	}
+}
+
+type depthwiseRealizer struct {
+	Creator anyvec.Creator
+}
+
+func (d depthwiseRealizer) Realize(chain convmarkup.RealizerChain, in convmarkup.Dims,
+	b convmarkup.Block) (interface{}, error) {
+	block, ok := b.(*depthwiseBlock)
+	if !ok {
+		return nil, convmarkup.ErrUnsupportedBlock
+	}
+	return NewDepthwiseConv(d.Creator, in.Width, in.Height, in.Depth, block.FilterWidth,
+		block.FilterHeight, block.StrideX, block.StrideY), nil
+}
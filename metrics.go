@@ -0,0 +1,202 @@
+package imagenet
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/unixpickle/essentials"
+)
+
+// Kinds of MetricsRecords.
+const (
+	TrainMetrics      = "train"
+	ValidationMetrics = "validation"
+)
+
+var metricsColumns = []string{"kind", "iter", "epoch", "wall_time", "learning_rate",
+	"train_loss", "images_per_sec", "validation_loss", "top1", "top5"}
+
+// A MetricsRecord is one entry in a training log.
+//
+// Train records describe a training step, while
+// validation records describe a validation pass.
+// Fields which do not apply to a record's kind are 0.
+type MetricsRecord struct {
+	Kind  string  `json:"kind"`
+	Iter  int     `json:"iter"`
+	Epoch float64 `json:"epoch"`
+
+	// WallTime is the number of seconds since training
+	// started.
+	WallTime float64 `json:"wall_time"`
+
+	LearningRate float64 `json:"learning_rate,omitempty"`
+	TrainLoss    float64 `json:"train_loss,omitempty"`
+	ImagesPerSec float64 `json:"images_per_sec,omitempty"`
+
+	ValidationLoss float64 `json:"validation_loss,omitempty"`
+	Top1           float64 `json:"top1,omitempty"`
+	Top5           float64 `json:"top5,omitempty"`
+}
+
+// A MetricsWriter appends MetricsRecords to a file.
+//
+// The format is determined by the file extension: ".csv"
+// files are written as CSV, and all other files are
+// written as JSON Lines.
+//
+// It is safe to use a MetricsWriter from multiple
+// Goroutines.
+type MetricsWriter struct {
+	lock sync.Mutex
+	file *os.File
+	csv  *csv.Writer
+}
+
+// NewMetricsWriter opens a metrics file for appending,
+// creating it if necessary.
+func NewMetricsWriter(path string) (*MetricsWriter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, essentials.AddCtx("open metrics", err)
+	}
+	res := &MetricsWriter{file: f}
+	if filepath.Ext(path) == ".csv" {
+		res.csv = csv.NewWriter(f)
+		if info, err := f.Stat(); err == nil && info.Size() == 0 {
+			res.csv.Write(metricsColumns)
+			res.csv.Flush()
+		}
+	}
+	return res, nil
+}
+
+// Write appends a record to the file.
+func (m *MetricsWriter) Write(r *MetricsRecord) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.csv != nil {
+		m.csv.Write(r.csvRow())
+		m.csv.Flush()
+		if err := m.csv.Error(); err != nil {
+			return essentials.AddCtx("write metrics", err)
+		}
+		return nil
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return essentials.AddCtx("write metrics", err)
+	}
+	if _, err := m.file.Write(append(data, '\n')); err != nil {
+		return essentials.AddCtx("write metrics", err)
+	}
+	return nil
+}
+
+// Close closes the underlying file.
+func (m *MetricsWriter) Close() error {
+	return m.file.Close()
+}
+
+// ReadMetrics reads all of the records from a metrics
+// file written by a MetricsWriter.
+func ReadMetrics(path string) ([]*MetricsRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, essentials.AddCtx("read metrics", err)
+	}
+	defer f.Close()
+	var res []*MetricsRecord
+	if filepath.Ext(path) == ".csv" {
+		res, err = readMetricsCSV(f)
+	} else {
+		res, err = readMetricsJSON(f)
+	}
+	if err != nil {
+		return nil, essentials.AddCtx("read metrics", err)
+	}
+	return res, nil
+}
+
+func readMetricsJSON(r io.Reader) ([]*MetricsRecord, error) {
+	var res []*MetricsRecord
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record MetricsRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, err
+		}
+		res = append(res, &record)
+	}
+	return res, scanner.Err()
+}
+
+func readMetricsCSV(r io.Reader) ([]*MetricsRecord, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	var res []*MetricsRecord
+	for i, row := range rows {
+		if i == 0 {
+			continue
+		}
+		if len(row) != len(metricsColumns) {
+			return nil, errors.New("unexpected number of columns")
+		}
+		nums := make([]float64, len(row))
+		for j, field := range row[1:] {
+			if field == "" {
+				continue
+			}
+			nums[j+1], err = strconv.ParseFloat(field, 64)
+			if err != nil {
+				return nil, err
+			}
+		}
+		res = append(res, &MetricsRecord{
+			Kind:           row[0],
+			Iter:           int(nums[1]),
+			Epoch:          nums[2],
+			WallTime:       nums[3],
+			LearningRate:   nums[4],
+			TrainLoss:      nums[5],
+			ImagesPerSec:   nums[6],
+			ValidationLoss: nums[7],
+			Top1:           nums[8],
+			Top5:           nums[9],
+		})
+	}
+	return res, nil
+}
+
+func (m *MetricsRecord) csvRow() []string {
+	format := func(x float64) string {
+		if x == 0 {
+			return ""
+		}
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	}
+	return []string{
+		m.Kind,
+		strconv.Itoa(m.Iter),
+		strconv.FormatFloat(m.Epoch, 'g', -1, 64),
+		strconv.FormatFloat(m.WallTime, 'g', -1, 64),
+		format(m.LearningRate),
+		format(m.TrainLoss),
+		format(m.ImagesPerSec),
+		format(m.ValidationLoss),
+		format(m.Top1),
+		format(m.Top5),
+	}
+}
@@ -0,0 +1,45 @@
+package imagenet
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMetricsRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imagenet_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	records := []*MetricsRecord{
+		{Kind: TrainMetrics, Iter: 1, Epoch: 0.001, WallTime: 1.5, LearningRate: 0.1,
+			TrainLoss: 6.9, ImagesPerSec: 120},
+		{Kind: ValidationMetrics, Iter: 2, Epoch: 0.002, WallTime: 3, ValidationLoss: 6.8,
+			Top1: 0.01, Top5: 0.05},
+	}
+	for _, name := range []string{"metrics.jsonl", "metrics.csv"} {
+		path := filepath.Join(dir, name)
+		for i := 0; i < 2; i++ {
+			// Write in two sessions to make sure appending works.
+			w, err := NewMetricsWriter(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Write(records[i]); err != nil {
+				t.Fatal(err)
+			}
+			w.Close()
+		}
+		actual, err := ReadMetrics(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(actual, records) {
+			t.Errorf("%s: expected %v but got %v", name, records, actual)
+		}
+	}
+}
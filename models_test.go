@@ -0,0 +1,84 @@
+package imagenet
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/unixpickle/anydiff"
+	"github.com/unixpickle/anyvec/anyvec32"
+)
+
+func TestModelShapes(t *testing.T) {
+	models := []string{
+		"orig.txt",
+		"tiny_residual.txt",
+		"resnet_18.txt",
+		"resnet_34.txt",
+		"resnet_50.txt",
+		"resnet_101.txt",
+		"vgg_16.txt",
+		"mobilenet.txt",
+	}
+	const numClasses = 1000
+	const batchSize = 2
+
+	// In short mode, models which use global pooling are
+	// run at a small input size to save time.
+	const shortSize = 64
+
+	for _, name := range models {
+		t.Run(name, func(t *testing.T) {
+			code, err := ioutil.ReadFile(filepath.Join("train", "models", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := anyvec32.CurrentCreator()
+			net, err := NetFromMarkup(c, string(code))
+			if err != nil {
+				t.Fatal(err)
+			}
+			size := InputImageSize
+			if testing.Short() {
+				if resized, err := ResizeNet(net, shortSize, shortSize); err == nil {
+					net = resized
+					size = shortSize
+				}
+			}
+			in := anydiff.NewConst(c.MakeVector(size * size * 3 * batchSize))
+			out := net.Apply(in, batchSize).Output()
+			if out.Len() != numClasses*batchSize {
+				t.Errorf("expected %d outputs but got %d", numClasses*batchSize, out.Len())
+			}
+		})
+	}
+}
+
+func TestResizeNet(t *testing.T) {
+	for _, name := range []string{"resnet_18.txt", "mobilenet.txt"} {
+		code, err := ioutil.ReadFile(filepath.Join("train", "models", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := anyvec32.CurrentCreator()
+		net, err := NetFromMarkup(c, string(code))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, size := range []int{128, 288} {
+			resized, err := ResizeNet(net, size, size)
+			if err != nil {
+				t.Errorf("%s at %d: %v", name, size, err)
+				continue
+			}
+			if w, h, ok := InputSize(resized); !ok || w != size || h != size {
+				t.Errorf("%s at %d: unexpected input size %dx%d", name, size, w, h)
+			}
+			in := anydiff.NewConst(c.MakeVector(size * size * 3))
+			out := resized.Apply(in, 1).Output()
+			if out.Len() != 1000 {
+				t.Errorf("%s at %d: expected 1000 outputs but got %d", name, size, out.Len())
+			}
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// Command plot_metrics summarizes the metrics file from a
+// training run and optionally plots its curves.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+
+	"github.com/unixpickle/essentials"
+	"github.com/unixpickle/imagenet"
+)
+
+func main() {
+	var outPath string
+	var smoothing int
+	flag.StringVar(&outPath, "out", "", "output plot (.svg or .png)")
+	flag.IntVar(&smoothing, "smooth", 50, "moving average window for training loss (1 for none)")
+	flag.Parse()
+
+	if len(flag.Args()) != 1 {
+		essentials.Die("Usage: plot_metrics [flags] metrics_file")
+	}
+	if smoothing < 1 {
+		essentials.Die("-smooth must be at least 1")
+	}
+	records, err := imagenet.ReadMetrics(flag.Args()[0])
+	if err != nil {
+		essentials.Die(err)
+	}
+	if len(records) == 0 {
+		essentials.Die("no records in metrics file")
+	}
+
+	printSummary(records, smoothing)
+
+	if outPath == "" {
+		return
+	}
+	panels := makePanels(records, smoothing)
+	switch filepath.Ext(outPath) {
+	case ".svg":
+		err = writeSVG(outPath, panels)
+	case ".png":
+		err = writePNG(outPath, panels)
+	default:
+		essentials.Die("unsupported output format:", outPath)
+	}
+	if err != nil {
+		essentials.Die(err)
+	}
+}
+
+func printSummary(records []*imagenet.MetricsRecord, smoothing int) {
+	var train []*imagenet.MetricsRecord
+	var best *imagenet.MetricsRecord
+	var numValid int
+	for _, r := range records {
+		switch r.Kind {
+		case imagenet.TrainMetrics:
+			train = append(train, r)
+		case imagenet.ValidationMetrics:
+			numValid++
+			if best == nil || r.Top1 > best.Top1 {
+				best = r
+			}
+		}
+	}
+	last := records[len(records)-1]
+	fmt.Printf("iterations:    %d\n", last.Iter)
+	fmt.Printf("epochs:        %.3f\n", last.Epoch)
+	fmt.Printf("wall time:     %.1f hours\n", last.WallTime/3600)
+
+	if len(train) > 0 {
+		recent := train
+		if len(recent) > smoothing {
+			recent = recent[len(recent)-smoothing:]
+		}
+		var lossSum, speedSum float64
+		for _, r := range recent {
+			lossSum += r.TrainLoss
+			speedSum += r.ImagesPerSec
+		}
+		n := float64(len(recent))
+		fmt.Printf("train loss:    %f (last %d steps)\n", lossSum/n, len(recent))
+		fmt.Printf("throughput:    %.1f images/sec (last %d steps)\n", speedSum/n,
+			len(recent))
+		fmt.Printf("learning rate: %g\n", train[len(train)-1].LearningRate)
+	}
+	if best != nil {
+		fmt.Printf("validations:   %d\n", numValid)
+		fmt.Printf("best top-1:    %f (iter %d, top-5 %f, loss %f)\n", best.Top1,
+			best.Iter, best.Top5, best.ValidationLoss)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+
+	"github.com/unixpickle/imagenet"
+)
+
+const (
+	panelWidth  = 600
+	panelHeight = 300
+	panelMargin = 50
+)
+
+// A panel is one chart in a plot, containing one or more
+// curves that share axes.
+type panel struct {
+	Title  string
+	Curves []*curve
+}
+
+type curve struct {
+	Name  string
+	Color color.RGBA
+	X     []float64
+	Y     []float64
+}
+
+func makePanels(records []*imagenet.MetricsRecord, smoothing int) []*panel {
+	trainLoss := &curve{Name: "train loss", Color: color.RGBA{R: 0x1f, G: 0x77, B: 0xb4, A: 0xff}}
+	validLoss := &curve{Name: "validation loss", Color: color.RGBA{R: 0xff, G: 0x7f, B: 0x0e, A: 0xff}}
+	top1 := &curve{Name: "top-1", Color: color.RGBA{R: 0x2c, G: 0xa0, B: 0x2c, A: 0xff}}
+	top5 := &curve{Name: "top-5", Color: color.RGBA{R: 0xd6, G: 0x27, B: 0x28, A: 0xff}}
+	lr := &curve{Name: "learning rate", Color: color.RGBA{R: 0x94, G: 0x67, B: 0xbd, A: 0xff}}
+
+	var window []float64
+	for _, r := range records {
+		switch r.Kind {
+		case imagenet.TrainMetrics:
+			window = append(window, r.TrainLoss)
+			if len(window) > smoothing {
+				window = window[1:]
+			}
+			var sum float64
+			for _, x := range window {
+				sum += x
+			}
+			trainLoss.X = append(trainLoss.X, r.Epoch)
+			trainLoss.Y = append(trainLoss.Y, sum/float64(len(window)))
+			lr.X = append(lr.X, r.Epoch)
+			lr.Y = append(lr.Y, r.LearningRate)
+		case imagenet.ValidationMetrics:
+			validLoss.X = append(validLoss.X, r.Epoch)
+			validLoss.Y = append(validLoss.Y, r.ValidationLoss)
+			top1.X = append(top1.X, r.Epoch)
+			top1.Y = append(top1.Y, r.Top1)
+			top5.X = append(top5.X, r.Epoch)
+			top5.Y = append(top5.Y, r.Top5)
+		}
+	}
+	return []*panel{
+		{Title: "Loss", Curves: []*curve{trainLoss, validLoss}},
+		{Title: "Validation accuracy", Curves: []*curve{top1, top5}},
+		{Title: "Learning rate", Curves: []*curve{lr}},
+	}
+}
+
+// bounds computes the range of the data in a panel.
+func (p *panel) bounds() (minX, maxX, minY, maxY float64) {
+	minX, minY = math.Inf(1), math.Inf(1)
+	maxX, maxY = math.Inf(-1), math.Inf(-1)
+	for _, c := range p.Curves {
+		for i, x := range c.X {
+			minX, maxX = math.Min(minX, x), math.Max(maxX, x)
+			minY, maxY = math.Min(minY, c.Y[i]), math.Max(maxY, c.Y[i])
+		}
+	}
+	if math.IsInf(minX, 0) {
+		return 0, 1, 0, 1
+	}
+	if maxX == minX {
+		maxX = minX + 1
+	}
+	if maxY == minY {
+		maxY = minY + 1
+	}
+	return
+}
+
+// points maps a curve into pixel coordinates within a
+// panel whose top-left corner is at y=top.
+func (p *panel) points(c *curve, top int) []image.Point {
+	minX, maxX, minY, maxY := p.bounds()
+	width := float64(panelWidth - 2*panelMargin)
+	height := float64(panelHeight - 2*panelMargin)
+	var res []image.Point
+	for i, x := range c.X {
+		px := panelMargin + (x-minX)/(maxX-minX)*width
+		py := float64(top+panelHeight-panelMargin) - (c.Y[i]-minY)/(maxY-minY)*height
+		res = append(res, image.Pt(int(px+0.5), int(py+0.5)))
+	}
+	return res
+}
+
+func writeSVG(path string, panels []*panel) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" `+
+		`font-family="sans-serif" font-size="12">`+"\n", panelWidth,
+		panelHeight*len(panels))
+	fmt.Fprintf(w, `<rect width="100%%" height="100%%" fill="white"/>`+"\n")
+	for i, p := range panels {
+		top := i * panelHeight
+		minX, maxX, minY, maxY := p.bounds()
+		fmt.Fprintf(w, `<text x="%d" y="%d" font-size="14">%s</text>`+"\n",
+			panelMargin, top+panelMargin-20, p.Title)
+		fmt.Fprintf(w, `<rect x="%d" y="%d" width="%d" height="%d" fill="none" `+
+			`stroke="black"/>`+"\n", panelMargin, top+panelMargin,
+			panelWidth-2*panelMargin, panelHeight-2*panelMargin)
+		fmt.Fprintf(w, `<text x="%d" y="%d">%.3g</text>`+"\n", 2, top+panelMargin+4, maxY)
+		fmt.Fprintf(w, `<text x="%d" y="%d">%.3g</text>`+"\n", 2,
+			top+panelHeight-panelMargin, minY)
+		fmt.Fprintf(w, `<text x="%d" y="%d">epoch %.3g</text>`+"\n", panelMargin,
+			top+panelHeight-panelMargin+16, minX)
+		fmt.Fprintf(w, `<text x="%d" y="%d" text-anchor="end">epoch %.3g</text>`+"\n",
+			panelWidth-panelMargin, top+panelHeight-panelMargin+16, maxX)
+		for j, c := range p.Curves {
+			colorStr := fmt.Sprintf("#%02x%02x%02x", c.Color.R, c.Color.G, c.Color.B)
+			fmt.Fprintf(w, `<text x="%d" y="%d" text-anchor="end" fill="%s">%s</text>`+"\n",
+				panelWidth-panelMargin, top+panelMargin-20+14*j, colorStr, c.Name)
+			fmt.Fprintf(w, `<polyline fill="none" stroke="%s" points="`, colorStr)
+			for _, pt := range p.points(c, top) {
+				fmt.Fprintf(w, "%d,%d ", pt.X, pt.Y)
+			}
+			fmt.Fprintln(w, `"/>`)
+		}
+	}
+	fmt.Fprintln(w, "</svg>")
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writePNG renders the curves and axes to a PNG.
+// Unlike writeSVG, it does not draw any text.
+func writePNG(path string, panels []*panel) error {
+	img := image.NewRGBA(image.Rect(0, 0, panelWidth, panelHeight*len(panels)))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	black := color.RGBA{A: 0xff}
+	for i, p := range panels {
+		top := i * panelHeight
+		x0, x1 := panelMargin, panelWidth-panelMargin
+		y0, y1 := top+panelMargin, top+panelHeight-panelMargin
+		drawLine(img, image.Pt(x0, y0), image.Pt(x1, y0), black)
+		drawLine(img, image.Pt(x0, y1), image.Pt(x1, y1), black)
+		drawLine(img, image.Pt(x0, y0), image.Pt(x0, y1), black)
+		drawLine(img, image.Pt(x1, y0), image.Pt(x1, y1), black)
+		for _, c := range p.Curves {
+			pts := p.points(c, top)
+			for j := 1; j < len(pts); j++ {
+				drawLine(img, pts[j-1], pts[j], c.Color)
+			}
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func drawLine(img *image.RGBA, p1, p2 image.Point, c color.RGBA) {
+	dx, dy := p2.X-p1.X, p2.Y-p1.Y
+	steps := int(math.Max(math.Abs(float64(dx)), math.Abs(float64(dy))))
+	if steps == 0 {
+		img.SetRGBA(p1.X, p1.Y, c)
+		return
+	}
+	for i := 0; i <= steps; i++ {
+		x := p1.X + int(math.Round(float64(dx*i)/float64(steps)))
+		y := p1.Y + int(math.Round(float64(dy*i)/float64(steps)))
+		img.SetRGBA(x, y, c)
+	}
+}
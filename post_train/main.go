@@ -10,12 +10,12 @@ import (
 	"log"
 	"math/rand"
 	"os"
+	"runtime"
 	"time"
 
 	"github.com/unixpickle/anynet/anyconv"
-	"github.com/unixpickle/anynet/anyff"
-	"github.com/unixpickle/anynet/anysgd"
 	"github.com/unixpickle/imagenet"
+	"github.com/unixpickle/imagenet/backend"
 	"github.com/unixpickle/serializer"
 )
 
@@ -26,12 +26,17 @@ func main() {
 
 	var batchSize int
 	var sampleCount int
+	var workers int
+	var seed int64
 
 	flag.StringVar(&imgDir, "samples", "", "sample directory")
 	flag.StringVar(&inNet, "in", "", "input network")
 	flag.StringVar(&outNet, "out", "", "output network")
 	flag.IntVar(&batchSize, "batch", 8, "evaluation batch size")
 	flag.IntVar(&sampleCount, "total", 512, "total samples for BatchNorm replacement")
+	flag.Int64Var(&seed, "seed", 0, "random seed (default: based on time)")
+	flag.IntVar(&workers, "workers", runtime.NumCPU(), "image loading Goroutines")
+	backend.AddFlags()
 
 	flag.Parse()
 
@@ -42,16 +47,27 @@ func main() {
 		os.Exit(1)
 	}
 
+	desc, err := backend.Setup()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	log.Println("Using", desc)
+
 	log.Println("Loading samples...")
 	samples, err := imagenet.NewSampleList(imgDir)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Failed to read sample listing:", err)
 		os.Exit(1)
 	}
-	rand.Seed(time.Now().UnixNano())
-	anysgd.Shuffle(samples)
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	log.Println("Using random seed", seed)
+	gen := rand.New(rand.NewSource(seed))
+	samples.Shuffle(rand.New(rand.NewSource(gen.Int63())))
 	if sampleCount < samples.Len() {
-		samples = samples.Slice(0, sampleCount).(imagenet.SampleList)
+		samples = samples[:sampleCount]
 	}
 
 	log.Println("Loading network...")
@@ -64,8 +80,11 @@ func main() {
 	log.Println("Replacing BatchNorm layers...")
 	var numReplaced int
 	pt := &anyconv.PostTrainer{
-		Samples:   samples,
-		Fetcher:   &anyff.Trainer{},
+		Samples: samples,
+		Fetcher: &imagenet.Fetcher{
+			Workers: workers,
+			Rand:    rand.New(rand.NewSource(gen.Int63())),
+		},
 		BatchSize: batchSize,
 		Net:       cl.Net,
 		StatusFunc: func(bn *anyconv.BatchNorm) {
@@ -6,14 +6,16 @@ import (
 	"log"
 	"math/rand"
 	"os"
+	"runtime"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/unixpickle/anydiff"
-	"github.com/unixpickle/anynet/anysgd"
 	"github.com/unixpickle/anyvec"
 	"github.com/unixpickle/essentials"
 	"github.com/unixpickle/imagenet"
+	"github.com/unixpickle/imagenet/backend"
 	"github.com/unixpickle/serializer"
 
 	_ "github.com/unixpickle/batchnorm"
@@ -23,10 +25,21 @@ func main() {
 	var classifierPath string
 	var sampleDir string
 	var topN int
+	var splitList string
+	var workers int
+	var prefetch int
+	var seed int64
+	var size int
 
 	flag.StringVar(&classifierPath, "classifier", "", "classifier file")
 	flag.StringVar(&sampleDir, "samples", "", "sample directory")
 	flag.IntVar(&topN, "topn", 1, "top N rating")
+	flag.IntVar(&workers, "workers", runtime.NumCPU(), "image loading Goroutines")
+	flag.IntVar(&prefetch, "prefetch", 16, "images to load ahead of time")
+	flag.Int64Var(&seed, "seed", 0, "random seed for sample order (default: based on time)")
+	flag.IntVar(&size, "size", 0, "input resolution (default: the classifier's own)")
+	flag.StringVar(&splitList, "split", "", "only rate samples in this split list")
+	backend.AddFlags()
 
 	flag.Parse()
 
@@ -37,12 +50,26 @@ func main() {
 		os.Exit(1)
 	}
 
+	desc, err := backend.Setup()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	log.Println("Using", desc)
+
 	log.Println("Loading classifier...")
 	var classifier *imagenet.Classifier
 	if err := serializer.LoadAny(classifierPath, &classifier); err != nil {
 		fmt.Fprintln(os.Stderr, "Failed to load classifier:", err)
 		os.Exit(1)
 	}
+	if size != 0 {
+		classifier, err = classifier.Resize(size, size)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to resize classifier:", err)
+			os.Exit(1)
+		}
+	}
 
 	log.Println("Loading samples...")
 	samples, err := imagenet.NewSampleList(sampleDir)
@@ -50,9 +77,27 @@ func main() {
 		fmt.Fprintln(os.Stderr, "Failed to load samples:", err)
 		os.Exit(1)
 	}
+	if splitList != "" {
+		names, err := imagenet.ReadSplitList(splitList)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to load split:", err)
+			os.Exit(1)
+		}
+		var held imagenet.SampleList
+		for _, sample := range samples {
+			if names[sample.RelPath()] {
+				held = append(held, sample)
+			}
+		}
+		samples = held
+		log.Println("Using", samples.Len(), "samples from split list.")
+	}
 
-	rand.Seed(time.Now().UnixNano())
-	anysgd.Shuffle(samples)
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	log.Println("Using random seed", seed)
+	samples.Shuffle(rand.New(rand.NewSource(seed)))
 
 	sampleChan := make(chan *imagenet.Sample, 1)
 	go func() {
@@ -62,23 +107,50 @@ func main() {
 		close(sampleChan)
 	}()
 
+	loadedChan := make(chan *loadedSample, prefetch)
+	go func() {
+		loadSamples(workers, classifier, sampleChan, loadedChan)
+		close(loadedChan)
+	}()
+
 	outChan := make(chan bool)
 
 	go func() {
-		rateSamples(topN, classifier, sampleChan, outChan)
+		rateSamples(topN, classifier, loadedChan, outChan)
 		close(outChan)
 	}()
 
 	printResults(outChan)
 }
 
-func rateSamples(n int, c *imagenet.Classifier, samples <-chan *imagenet.Sample,
+type loadedSample struct {
+	Sample *imagenet.Sample
+	Images []anyvec.Vector
+}
+
+func loadSamples(workers int, c *imagenet.Classifier, samples <-chan *imagenet.Sample,
+	out chan<- *loadedSample) {
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for sample := range samples {
+				ins, err := c.TestingImages(sample.Path)
+				if err != nil {
+					essentials.Die(err)
+				}
+				out <- &loadedSample{Sample: sample, Images: ins}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func rateSamples(n int, c *imagenet.Classifier, samples <-chan *loadedSample,
 	out chan<- bool) {
-	for sample := range samples {
-		ins, err := imagenet.TestingImages(sample.Path)
-		if err != nil {
-			essentials.Die(err)
-		}
+	for loaded := range samples {
+		sample, ins := loaded.Sample, loaded.Images
 		var outSum anyvec.Vector
 		for _, x := range ins {
 			res := anydiff.Exp(c.Net.Apply(anydiff.NewConst(x), 1)).Output()
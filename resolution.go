@@ -0,0 +1,256 @@
+package imagenet
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/unixpickle/anynet"
+	"github.com/unixpickle/anynet/anyconv"
+	"github.com/unixpickle/essentials"
+)
+
+// Resize creates a classifier which runs the same network
+// on inputs of a different size.
+//
+// The new classifier shares its parameters with c.
+// See ResizeNet for the networks which can be resized.
+func (c *Classifier) Resize(width, height int) (*Classifier, error) {
+	if width == c.InWidth && height == c.InHeight {
+		return c, nil
+	}
+	net, err := ResizeNet(c.Net, width, height)
+	if err != nil {
+		return nil, err
+	}
+	return &Classifier{
+		InWidth:    width,
+		InHeight:   height,
+		Net:        net,
+		Classes:    c.Classes,
+		ConfigHash: c.ConfigHash,
+	}, nil
+}
+
+// ResizeNet adapts a convolutional network to a new input
+// width and height.
+//
+// The resulting network shares its parameters with the
+// original network.
+//
+// Convolutions, padding, and pooling are applied to the
+// new input size.
+// A MeanPool which covers its entire input is treated as
+// global average pooling, so its window grows or shrinks
+// with the input.
+// Thus, a network which uses global average pooling before
+// its fully-connected layers can be used at any input
+// size, provided that the input is large enough.
+//
+// Layers which are not recognized are assumed not to
+// change the shape of their input.
+func ResizeNet(net anynet.Net, width, height int) (anynet.Net, error) {
+	res, _, err := resizeLayer(net, tensorDims{Width: width, Height: height, Depth: 3})
+	if err != nil {
+		return nil, essentials.AddCtx("resize network", err)
+	}
+	return res.(anynet.Net), nil
+}
+
+// InputSize finds the input width and height of a
+// convolutional network by looking at its first layer
+// which depends on the input size.
+//
+// It returns false if the network starts with layers
+// which do not know their input size, like FC layers.
+func InputSize(net anynet.Net) (width, height int, ok bool) {
+	return layerInputSize(net)
+}
+
+func layerInputSize(layer anynet.Layer) (width, height int, ok bool) {
+	switch layer := layer.(type) {
+	case anynet.Net:
+		for _, sub := range layer {
+			if _, isFC := sub.(*anynet.FC); isFC {
+				return 0, 0, false
+			}
+			if width, height, ok = layerInputSize(sub); ok {
+				return
+			}
+		}
+	case *anyconv.Residual:
+		return layerInputSize(layer.Layer)
+	case *anyconv.Conv:
+		return layer.InputWidth, layer.InputHeight, true
+	case *DepthwiseConv:
+		return layer.InputWidth, layer.InputHeight, true
+	case *anyconv.Padding:
+		return layer.InputWidth, layer.InputHeight, true
+	case *anyconv.MaxPool:
+		return layer.InputWidth, layer.InputHeight, true
+	case *anyconv.MeanPool:
+		return layer.InputWidth, layer.InputHeight, true
+	}
+	return 0, 0, false
+}
+
+type tensorDims struct {
+	Width  int
+	Height int
+	Depth  int
+}
+
+func (t tensorDims) Size() int {
+	return t.Width * t.Height * t.Depth
+}
+
+// resizeLayer creates a version of the layer for inputs of
+// the given dimensions, and computes the dimensions of the
+// new layer's output.
+func resizeLayer(layer anynet.Layer, in tensorDims) (anynet.Layer, tensorDims, error) {
+	switch layer := layer.(type) {
+	case anynet.Net:
+		var res anynet.Net
+		for _, sub := range layer {
+			newSub, out, err := resizeLayer(sub, in)
+			if err != nil {
+				return nil, in, err
+			}
+			res = append(res, newSub)
+			in = out
+		}
+		return res, in, nil
+	case *anyconv.Residual:
+		newLayer, out, err := resizeLayer(layer.Layer, in)
+		if err != nil {
+			return nil, in, err
+		}
+		res := &anyconv.Residual{Layer: newLayer}
+		if layer.Projection != nil {
+			res.Projection, _, err = resizeLayer(layer.Projection, in)
+			if err != nil {
+				return nil, in, err
+			}
+		}
+		return res, out, nil
+	case *anyconv.Conv:
+		if in.Depth != layer.InputDepth {
+			return nil, in, errors.New("Conv: unexpected input depth")
+		}
+		out := tensorDims{
+			Width:  1 + (in.Width-layer.FilterWidth)/layer.StrideX,
+			Height: 1 + (in.Height-layer.FilterHeight)/layer.StrideY,
+			Depth:  layer.FilterCount,
+		}
+		if err := checkLayerOutput("Conv", out); err != nil {
+			return nil, in, err
+		}
+		return &anyconv.Conv{
+			FilterCount:  layer.FilterCount,
+			FilterWidth:  layer.FilterWidth,
+			FilterHeight: layer.FilterHeight,
+			StrideX:      layer.StrideX,
+			StrideY:      layer.StrideY,
+			InputWidth:   in.Width,
+			InputHeight:  in.Height,
+			InputDepth:   in.Depth,
+			Filters:      layer.Filters,
+			Biases:       layer.Biases,
+		}, out, nil
+	case *DepthwiseConv:
+		if in.Depth != layer.InputDepth {
+			return nil, in, errors.New("DepthwiseConv: unexpected input depth")
+		}
+		res := &DepthwiseConv{
+			FilterWidth:  layer.FilterWidth,
+			FilterHeight: layer.FilterHeight,
+			StrideX:      layer.StrideX,
+			StrideY:      layer.StrideY,
+			InputWidth:   in.Width,
+			InputHeight:  in.Height,
+			InputDepth:   in.Depth,
+			Filters:      layer.Filters,
+			Biases:       layer.Biases,
+		}
+		out := tensorDims{
+			Width:  res.OutputWidth(),
+			Height: res.OutputHeight(),
+			Depth:  in.Depth,
+		}
+		if err := checkLayerOutput("DepthwiseConv", out); err != nil {
+			return nil, in, err
+		}
+		return res, out, nil
+	case *anyconv.Padding:
+		out := tensorDims{
+			Width:  in.Width + layer.PaddingLeft + layer.PaddingRight,
+			Height: in.Height + layer.PaddingTop + layer.PaddingBottom,
+			Depth:  in.Depth,
+		}
+		return &anyconv.Padding{
+			InputWidth:    in.Width,
+			InputHeight:   in.Height,
+			InputDepth:    in.Depth,
+			PaddingTop:    layer.PaddingTop,
+			PaddingRight:  layer.PaddingRight,
+			PaddingBottom: layer.PaddingBottom,
+			PaddingLeft:   layer.PaddingLeft,
+		}, out, nil
+	case *anyconv.MaxPool:
+		out := tensorDims{
+			Width:  1 + (in.Width-layer.SpanX)/layer.StrideX,
+			Height: 1 + (in.Height-layer.SpanY)/layer.StrideY,
+			Depth:  in.Depth,
+		}
+		if err := checkLayerOutput("MaxPool", out); err != nil {
+			return nil, in, err
+		}
+		return &anyconv.MaxPool{
+			SpanX:       layer.SpanX,
+			SpanY:       layer.SpanY,
+			StrideX:     layer.StrideX,
+			StrideY:     layer.StrideY,
+			InputWidth:  in.Width,
+			InputHeight: in.Height,
+			InputDepth:  in.Depth,
+		}, out, nil
+	case *anyconv.MeanPool:
+		spanX, spanY := layer.SpanX, layer.SpanY
+		strideX, strideY := layer.StrideX, layer.StrideY
+		if spanX == layer.InputWidth && spanY == layer.InputHeight {
+			spanX, spanY = in.Width, in.Height
+			strideX, strideY = in.Width, in.Height
+		}
+		out := tensorDims{
+			Width:  1 + (in.Width-spanX)/strideX,
+			Height: 1 + (in.Height-spanY)/strideY,
+			Depth:  in.Depth,
+		}
+		if err := checkLayerOutput("MeanPool", out); err != nil {
+			return nil, in, err
+		}
+		return &anyconv.MeanPool{
+			SpanX:       spanX,
+			SpanY:       spanY,
+			StrideX:     strideX,
+			StrideY:     strideY,
+			InputWidth:  in.Width,
+			InputHeight: in.Height,
+			InputDepth:  in.Depth,
+		}, out, nil
+	case *anynet.FC:
+		if layer.InCount != in.Size() {
+			return nil, in, fmt.Errorf("FC: expected %d inputs but got %d", layer.InCount,
+				in.Size())
+		}
+		return layer, tensorDims{Width: 1, Height: 1, Depth: layer.OutCount}, nil
+	default:
+		return layer, in, nil
+	}
+}
+
+func checkLayerOutput(name string, out tensorDims) error {
+	if out.Width < 1 || out.Height < 1 {
+		return errors.New(name + ": input is too small")
+	}
+	return nil
+}
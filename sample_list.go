@@ -1,9 +1,12 @@
 package imagenet
 
 import (
+	"bufio"
 	"crypto/md5"
 	"errors"
 	"io/ioutil"
+	"math/rand"
+	"os"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -66,6 +69,58 @@ func NewSampleList(dir string) (SampleList, error) {
 	return res, nil
 }
 
+// NewAlignedSampleList creates a sample set whose class
+// indices correspond to the given class names, such as
+// the Classes of a Classifier.
+//
+// The path may either be a sample directory, laid out
+// like the directories for NewSampleList, or a manifest
+// file.
+// Each line of a manifest contains an image path followed
+// by whitespace and a class name.
+// Relative image paths are resolved relative to the
+// directory containing the manifest.
+//
+// It is an error for a sample to belong to a class that
+// is not in the list of classes.
+func NewAlignedSampleList(path string, classes []string) (SampleList, error) {
+	classIdx := map[string]int{}
+	for i, name := range classes {
+		classIdx[name] = i
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	var paths, names []string
+	if info.IsDir() {
+		paths, names, err = readSampleDir(path)
+	} else {
+		paths, names, err = readManifest(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var res SampleList
+	for i, p := range paths {
+		class, ok := classIdx[names[i]]
+		if !ok {
+			return nil, errors.New("unknown class: " + names[i])
+		}
+		res = append(res, Sample{
+			ClassCount: len(classes),
+			Class:      class,
+			Path:       p,
+		})
+	}
+	if len(res) == 0 {
+		return nil, errors.New("no images found")
+	}
+	return res, nil
+}
+
 // ClassCount returns the number of classes in the set.
 func (s SampleList) ClassCount() int {
 	return s[0].ClassCount
@@ -83,10 +138,33 @@ func (s SampleList) Slice(start, end int) anysgd.SampleList {
 	return append(SampleList{}, s[start:end]...)
 }
 
+// GetSample loads a sample, drawing its augmentations
+// from the global random source.
+// Use GetSampleRand for reproducible results.
 func (s SampleList) GetSample(idx int) (*anyff.Sample, error) {
+	return s.GetSampleRand(idx, rand.New(rand.NewSource(rand.Int63())))
+}
+
+// GetSampleRand loads a sample, drawing its augmentations
+// from gen.
+func (s SampleList) GetSampleRand(idx int, gen *rand.Rand) (*anyff.Sample, error) {
+	return s.GetAugmentedSample(idx, &DefaultAugmentation, gen)
+}
+
+// GetAugmentedSample loads a sample with the given
+// augmentation, drawing the random choices from gen.
+func (s SampleList) GetAugmentedSample(idx int, aug *Augmentation,
+	gen *rand.Rand) (*anyff.Sample, error) {
+	return s.GetSizedSample(idx, aug, InputImageSize, InputImageSize, gen)
+}
+
+// GetSizedSample is like GetAugmentedSample, but produces
+// an input tensor with the given width and height.
+func (s SampleList) GetSizedSample(idx int, aug *Augmentation, width, height int,
+	gen *rand.Rand) (*anyff.Sample, error) {
 	outVec := make([]float64, s[idx].ClassCount)
 	outVec[s[idx].Class] = 1
-	in, err := TrainingImage(s[idx].Path)
+	in, err := aug.SizedImage(s[idx].Path, width, height, gen)
 	if err != nil {
 		return nil, essentials.AddCtx("get sample", err)
 	}
@@ -97,6 +175,37 @@ func (s SampleList) GetSample(idx int) (*anyff.Sample, error) {
 	return sample, nil
 }
 
+// GetTestingSample loads a sample without augmentation,
+// using the center crop of the image.
+func (s SampleList) GetTestingSample(idx int) (*anyff.Sample, error) {
+	return s.GetSizedTestingSample(idx, InputImageSize, InputImageSize)
+}
+
+// GetSizedTestingSample is like GetTestingSample, but
+// produces an input tensor with the given width and height.
+func (s SampleList) GetSizedTestingSample(idx int, width, height int) (*anyff.Sample,
+	error) {
+	outVec := make([]float64, s[idx].ClassCount)
+	outVec[s[idx].Class] = 1
+	in, err := testingCenterImage(s[idx].Path, width, height)
+	if err != nil {
+		return nil, essentials.AddCtx("get sample", err)
+	}
+	sample := &anyff.Sample{
+		Input:  in,
+		Output: in.Creator().MakeVectorData(in.Creator().MakeNumericList(outVec)),
+	}
+	return sample, nil
+}
+
+// Shuffle randomly permutes the list using gen.
+func (s SampleList) Shuffle(gen *rand.Rand) {
+	for i := len(s) - 1; i > 0; i-- {
+		j := gen.Intn(i + 1)
+		s[i], s[j] = s[j], s[i]
+	}
+}
+
 // Hash returns the hash of the given sample's base
 // filename (e.g. "apple1.png").
 func (s SampleList) Hash(idx int) []byte {
@@ -104,3 +213,53 @@ func (s SampleList) Hash(idx int) []byte {
 	hash := md5.Sum([]byte(name))
 	return hash[:]
 }
+
+func readSampleDir(dir string) (paths, classes []string, err error) {
+	imageDirs, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, item := range imageDirs {
+		if !item.IsDir() {
+			continue
+		}
+		listing, err := ioutil.ReadDir(filepath.Join(dir, item.Name()))
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, fileItem := range listing {
+			if strings.HasPrefix(fileItem.Name(), ".") {
+				continue
+			}
+			paths = append(paths, filepath.Join(dir, item.Name(), fileItem.Name()))
+			classes = append(classes, item.Name())
+		}
+	}
+	return paths, classes, nil
+}
+
+func readManifest(path string) (paths, classes []string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, nil, errors.New("bad manifest line: " + line)
+		}
+		imagePath := fields[0]
+		if !filepath.IsAbs(imagePath) {
+			imagePath = filepath.Join(filepath.Dir(path), imagePath)
+		}
+		paths = append(paths, imagePath)
+		classes = append(classes, fields[1])
+	}
+	return paths, classes, scanner.Err()
+}
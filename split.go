@@ -0,0 +1,294 @@
+package imagenet
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/unixpickle/anynet/anysgd"
+	"github.com/unixpickle/essentials"
+)
+
+// A SplitStrategy determines how a SampleList is divided
+// into validation and training samples.
+type SplitStrategy int
+
+const (
+	// FilenameSplit splits samples based on the hashes of
+	// their base filenames (see SampleList.Hash).
+	FilenameSplit SplitStrategy = iota
+
+	// ContentSplit splits samples based on the hashes of
+	// their file contents, so that duplicate images always
+	// end up on the same side of the split.
+	ContentSplit
+
+	// StratifiedSplit deterministically holds out the same
+	// fraction of every class.
+	StratifiedSplit
+
+	// ListSplit holds out exactly the samples named in a
+	// split list file (see WriteSplitList).
+	ListSplit
+)
+
+// ParseSplitStrategy parses a strategy name, as produced
+// by SplitStrategy.String().
+func ParseSplitStrategy(name string) (SplitStrategy, error) {
+	for _, s := range []SplitStrategy{FilenameSplit, ContentSplit, StratifiedSplit,
+		ListSplit} {
+		if s.String() == name {
+			return s, nil
+		}
+	}
+	return 0, errors.New("unknown split strategy: " + name)
+}
+
+// String returns the name of the strategy.
+func (s SplitStrategy) String() string {
+	switch s {
+	case FilenameSplit:
+		return "filename"
+	case ContentSplit:
+		return "content"
+	case StratifiedSplit:
+		return "stratified"
+	case ListSplit:
+		return "list"
+	default:
+		return "unknown"
+	}
+}
+
+// Split divides the list into validation and training
+// samples.
+//
+// For ListSplit, listPath names the split list file and
+// fraction is ignored.
+// For all other strategies, fraction is the approximate
+// fraction of validation samples and listPath is ignored.
+//
+// For ContentSplit, an unreadable file causes an error.
+// Use SplitByContent to tolerate unreadable files.
+func (s SampleList) Split(strategy SplitStrategy, fraction float64,
+	listPath string) (validation, training SampleList, err error) {
+	switch strategy {
+	case FilenameSplit:
+		v, t := anysgd.HashSplit(s, fraction)
+		return v.(SampleList), t.(SampleList), nil
+	case ContentSplit:
+		return s.SplitByContent(fraction, FailOnBadSamples, nil)
+	case StratifiedSplit:
+		validation, training = s.stratifiedSplit(fraction)
+	case ListSplit:
+		names, err := ReadSplitList(listPath)
+		if err != nil {
+			return nil, nil, essentials.AddCtx("split samples", err)
+		}
+		for _, sample := range s {
+			if names[sample.RelPath()] {
+				validation = append(validation, sample)
+			} else {
+				training = append(training, sample)
+			}
+		}
+	default:
+		return nil, nil, errors.New("split samples: unknown strategy")
+	}
+	if len(validation) == 0 || len(training) == 0 {
+		return nil, nil, errors.New("split samples: empty validation or training set")
+	}
+	return validation, training, nil
+}
+
+// SplitByContent splits the list like Split does for the
+// ContentSplit strategy, but deals with unreadable files
+// according to a bad sample policy.
+//
+// With FailOnBadSamples, an unreadable file causes an
+// error.
+// Otherwise, unreadable files are split by the hashes of
+// their filenames, leaving it to the Fetcher to skip or
+// replace them, and logFunc (if non-nil) is called for
+// each of them.
+func (s SampleList) SplitByContent(fraction float64, policy BadSamplePolicy,
+	logFunc func(s *Sample, err error)) (validation, training SampleList, err error) {
+	hashes, errs := s.contentHashes()
+	for i, sample := range s {
+		hash := hashes[i]
+		if errs[i] != nil {
+			if policy == FailOnBadSamples {
+				return nil, nil, essentials.AddCtx("split samples", errs[i])
+			}
+			if logFunc != nil {
+				logFunc(&s[i], errs[i])
+			}
+			hash = s.Hash(i)
+		}
+		if hashFraction(hash) < fraction {
+			validation = append(validation, sample)
+		} else {
+			training = append(training, sample)
+		}
+	}
+	if len(validation) == 0 || len(training) == 0 {
+		return nil, nil, errors.New("split samples: empty validation or training set")
+	}
+	return validation, training, nil
+}
+
+// ReadSplitList reads a split list file and returns the
+// set of relative sample paths it contains.
+func ReadSplitList(path string) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, essentials.AddCtx("read split list", err)
+	}
+	defer f.Close()
+	names := map[string]bool{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			names[filepath.ToSlash(line)] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, essentials.AddCtx("read split list", err)
+	}
+	return names, nil
+}
+
+// WriteSplitList records the samples in a split list
+// file, which can later be used with ListSplit to recover
+// the same held-out set.
+//
+// Each line contains the path of a sample relative to the
+// sample directory, e.g. "n01440764/apple1.JPEG".
+func WriteSplitList(path string, samples SampleList) error {
+	if err := writeSplitList(path, samples); err != nil {
+		return essentials.AddCtx("write split list", err)
+	}
+	return nil
+}
+
+func writeSplitList(path string, samples SampleList) error {
+	var names []string
+	for _, sample := range samples {
+		names = append(names, sample.RelPath())
+	}
+	sort.Strings(names)
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, name := range names {
+		if _, err := w.WriteString(name + "\n"); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s SampleList) stratifiedSplit(fraction float64) (validation, training SampleList) {
+	byClass := map[int][]int{}
+	for i, sample := range s {
+		byClass[sample.Class] = append(byClass[sample.Class], i)
+	}
+	for _, indices := range byClass {
+		sort.Slice(indices, func(i, j int) bool {
+			return string(s.Hash(indices[i])) < string(s.Hash(indices[j]))
+		})
+		numValidation := int(math.Floor(fraction*float64(len(indices)) + 0.5))
+		for i, idx := range indices {
+			if i < numValidation {
+				validation = append(validation, s[idx])
+			} else {
+				training = append(training, s[idx])
+			}
+		}
+	}
+	sort.Sort(samplesByPath(validation))
+	sort.Sort(samplesByPath(training))
+	return
+}
+
+// contentHashes hashes the file of every sample.
+// If a file cannot be read, its hash is nil and its entry
+// in errs is set.
+func (s SampleList) contentHashes() (hashes [][]byte, errs []error) {
+	hashes = make([][]byte, len(s))
+	errs = make([]error, len(s))
+	indices := make(chan int, len(s))
+	for i := range s {
+		indices <- i
+	}
+	close(indices)
+
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range indices {
+				hashes[idx], errs[idx] = hashFile(s[idx].Path)
+			}
+		}()
+	}
+	wg.Wait()
+	return hashes, errs
+}
+
+// RelPath returns the path of the sample relative to the
+// sample directory, as it appears in split list files.
+func (s *Sample) RelPath() string {
+	return filepath.ToSlash(filepath.Join(filepath.Base(filepath.Dir(s.Path)),
+		filepath.Base(s.Path)))
+}
+
+func hashFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// hashFraction maps a hash to a number in [0, 1).
+func hashFraction(hash []byte) float64 {
+	return float64(binary.BigEndian.Uint64(hash)>>11) / (1 << 53)
+}
+
+type samplesByPath SampleList
+
+func (s samplesByPath) Len() int {
+	return len(s)
+}
+
+func (s samplesByPath) Less(i, j int) bool {
+	return s[i].Path < s[j].Path
+}
+
+func (s samplesByPath) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
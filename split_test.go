@@ -0,0 +1,114 @@
+package imagenet
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestStratifiedSplit(t *testing.T) {
+	var samples SampleList
+	for class := 0; class < 3; class++ {
+		for i := 0; i < 20; i++ {
+			samples = append(samples, Sample{
+				ClassCount: 3,
+				Class:      class,
+				Path:       filepath.Join("c"+strconv.Itoa(class), strconv.Itoa(i)+".jpg"),
+			})
+		}
+	}
+	validation, training, err := samples.Split(StratifiedSplit, 0.25, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(validation) != 15 || len(training) != 45 {
+		t.Fatalf("bad split sizes: %d, %d", len(validation), len(training))
+	}
+	counts := map[int]int{}
+	for _, s := range validation {
+		counts[s.Class]++
+	}
+	for class := 0; class < 3; class++ {
+		if counts[class] != 5 {
+			t.Errorf("class %d: expected 5 validation samples but got %d", class,
+				counts[class])
+		}
+	}
+
+	validation1, _, _ := samples.Split(StratifiedSplit, 0.25, "")
+	for i, s := range validation1 {
+		if s != validation[i] {
+			t.Fatal("split is not deterministic")
+		}
+	}
+}
+
+func TestListSplit(t *testing.T) {
+	samples := SampleList{
+		{ClassCount: 2, Class: 0, Path: "/data/a/1.jpg"},
+		{ClassCount: 2, Class: 0, Path: "/data/a/2.jpg"},
+		{ClassCount: 2, Class: 1, Path: "/data/b/1.jpg"},
+		{ClassCount: 2, Class: 1, Path: "/data/b/2.jpg"},
+	}
+	f, err := ioutil.TempFile("", "imagenet_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	if err := WriteSplitList(f.Name(), SampleList{samples[1], samples[2]}); err != nil {
+		t.Fatal(err)
+	}
+	validation, training, err := samples.Split(ListSplit, 0, f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(validation) != 2 || validation[0] != samples[1] ||
+		validation[1] != samples[2] {
+		t.Errorf("unexpected validation set: %v", validation)
+	}
+	if len(training) != 2 || training[0] != samples[0] || training[1] != samples[3] {
+		t.Errorf("unexpected training set: %v", training)
+	}
+}
+
+func TestSplitByContentBadFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imagenet_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var samples SampleList
+	for i := 0; i < 20; i++ {
+		path := filepath.Join(dir, strconv.Itoa(i)+".jpg")
+		if err := ioutil.WriteFile(path, []byte(strconv.Itoa(i)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		samples = append(samples, Sample{ClassCount: 1, Path: path})
+	}
+	samples = append(samples, Sample{ClassCount: 1, Path: filepath.Join(dir, "missing.jpg")})
+
+	if _, _, err := samples.SplitByContent(0.5, FailOnBadSamples, nil); err == nil {
+		t.Error("expected an error for the missing file")
+	}
+
+	var numLogged int
+	validation, training, err := samples.SplitByContent(0.5, SkipBadSamples,
+		func(s *Sample, err error) {
+			numLogged++
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if numLogged != 1 {
+		t.Errorf("expected 1 bad file but got %d", numLogged)
+	}
+	if len(validation)+len(training) != len(samples) {
+		t.Errorf("expected %d samples but got %d", len(samples),
+			len(validation)+len(training))
+	}
+}
@@ -0,0 +1,247 @@
+// Command sweep runs train with different hyper-parameters
+// and ranks the results by validation accuracy.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/unixpickle/rip"
+)
+
+func main() {
+	var sampleDir string
+	var outDir string
+	var trainPath string
+	var mode string
+	var numTrials int
+	var parallel int
+	var duration time.Duration
+	var numClasses int
+	var seed int64
+	var validInterval int
+	var validSubset int
+	var stepSpec, decaySpec, momentumSpec, batchSpec string
+
+	flag.StringVar(&sampleDir, "samples", "", "sample directory")
+	flag.StringVar(&outDir, "out", "", "sweep output directory")
+	flag.StringVar(&trainPath, "train", "train", "path to the train command")
+	flag.StringVar(&mode, "mode", "grid", "search mode (grid or random)")
+	flag.IntVar(&numTrials, "trials", 10, "number of trials for random search")
+	flag.IntVar(&parallel, "parallel", 1, "trials to run at once")
+	flag.DurationVar(&duration, "duration", 10*time.Minute,
+		"training time per trial (0 for no limit)")
+	flag.IntVar(&numClasses, "classes", 0, "number of classes to train on (0 for all)")
+	flag.Int64Var(&seed, "seed", 0, "random seed (default: based on time)")
+	flag.IntVar(&validInterval, "valint", 100, "iterations between validation runs")
+	flag.IntVar(&validSubset, "valsubset", 0,
+		"validation samples per run (0 for all)")
+	flag.StringVar(&stepSpec, "step", "", "step sizes (e.g. 0.1,0.01 or 0.0001:0.1:log)")
+	flag.StringVar(&decaySpec, "decay", "", "L2 weight decays")
+	flag.StringVar(&momentumSpec, "momentum", "", "momentum values")
+	flag.StringVar(&batchSpec, "batch", "", "batch sizes")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: sweep [flags] [-- train flags]")
+		fmt.Fprintln(os.Stderr)
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if sampleDir == "" || outDir == "" {
+		fmt.Fprintln(os.Stderr, "Required flags: -samples and -out")
+		fmt.Fprintln(os.Stderr)
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if parallel < 1 {
+		fmt.Fprintln(os.Stderr, "-parallel must be at least 1")
+		os.Exit(1)
+	}
+
+	var params []*Param
+	specs := []struct {
+		Name    string
+		Spec    string
+		Integer bool
+	}{
+		{"step", stepSpec, false},
+		{"decay", decaySpec, false},
+		{"momentum", momentumSpec, false},
+		{"batch", batchSpec, true},
+	}
+	for _, spec := range specs {
+		if spec.Spec == "" {
+			continue
+		}
+		param, err := ParseParam(spec.Name, spec.Spec, spec.Integer)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		params = append(params, param)
+	}
+	if len(params) == 0 {
+		fmt.Fprintln(os.Stderr, "Nothing to sweep: pass -step, -decay, -momentum, or -batch")
+		os.Exit(1)
+	}
+
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	log.Println("Using seed", seed)
+	gen := rand.New(rand.NewSource(seed))
+
+	var trialValues [][]float64
+	switch mode {
+	case "grid":
+		var err error
+		trialValues, err = GridTrials(params)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case "random":
+		trialValues = RandomTrials(params, numTrials, gen)
+	default:
+		fmt.Fprintln(os.Stderr, "Unknown search mode:", mode)
+		os.Exit(1)
+	}
+
+	trainPath, err := exec.LookPath(trainPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to find train command:", err)
+		os.Exit(1)
+	}
+
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to create output directory:", err)
+		os.Exit(1)
+	}
+
+	trials := make([]*Trial, len(trialValues))
+	for i, values := range trialValues {
+		trials[i] = &Trial{
+			Index:  i,
+			Params: params,
+			Values: values,
+			Dir:    filepath.Join(outDir, fmt.Sprintf("trial-%03d", i)),
+		}
+		if _, err := os.Stat(trials[i].Dir); err == nil {
+			fmt.Fprintln(os.Stderr, "Trial directory already exists:", trials[i].Dir)
+			os.Exit(1)
+		}
+	}
+
+	var fitOutput bool
+	if numClasses > 0 {
+		classes, err := listClasses(sampleDir)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to list classes:", err)
+			os.Exit(1)
+		}
+		if numClasses < len(classes) {
+			perm := gen.Perm(len(classes))
+			var subset []string
+			for _, i := range perm[:numClasses] {
+				subset = append(subset, classes[i])
+			}
+			subsetDir := filepath.Join(outDir, "samples")
+			log.Printf("Creating subset of %d classes in %s...", numClasses, subsetDir)
+			if err := makeClassSubset(sampleDir, subsetDir, subset); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			sampleDir = subsetDir
+			fitOutput = true
+		}
+	}
+
+	baseArgs := []string{
+		"-samples", sampleDir,
+		"-seed", fmt.Sprint(seed),
+		"-valint", fmt.Sprint(validInterval),
+		"-valsubset", fmt.Sprint(validSubset),
+	}
+	if fitOutput {
+		baseArgs = append(baseArgs, "-fitoutput")
+	}
+	baseArgs = append(baseArgs, flag.Args()...)
+
+	log.Printf("Running %d trials (press ctrl+c once to stop)...", len(trials))
+	stop := rip.NewRIP().Chan()
+	runTrials(trials, parallel, func(t *Trial) {
+		log.Printf("Starting trial %d: %s", t.Index, t.Describe())
+		t.Run(trainPath, baseArgs, duration, stop)
+		if t.Err != nil {
+			log.Printf("Trial %d failed: %v", t.Index, t.Err)
+		} else if t.Best != nil {
+			log.Printf("Finished trial %d: top1=%.4f loss=%.4f", t.Index, t.Best.Top1,
+				t.Best.ValidationLoss)
+		} else {
+			log.Printf("Finished trial %d without validation results", t.Index)
+		}
+	}, stop)
+
+	var finished []*Trial
+	for _, t := range trials {
+		if t.Duration > 0 {
+			finished = append(finished, t)
+		}
+	}
+	rankTrials(finished)
+
+	resultsPath := filepath.Join(outDir, "results.txt")
+	resultsFile, err := os.Create(resultsPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to create results:", err)
+		os.Exit(1)
+	}
+	defer resultsFile.Close()
+	if err := writeResults(resultsFile, params, finished); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to write results:", err)
+		os.Exit(1)
+	}
+	writeResults(os.Stdout, params, finished)
+	log.Println("Saved results to", resultsPath)
+}
+
+// runTrials runs up to parallel trials at once.
+// No new trials are started once stop is closed.
+func runTrials(trials []*Trial, parallel int, f func(t *Trial), stop <-chan struct{}) {
+	trialChan := make(chan *Trial)
+	go func() {
+		defer close(trialChan)
+		for _, t := range trials {
+			select {
+			case trialChan <- t:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range trialChan {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				f(t)
+			}
+		}()
+	}
+	wg.Wait()
+}
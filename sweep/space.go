@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// A Param is a hyper-parameter of train to search over.
+//
+// A Param either has a list of Values or, for random
+// search only, a range from Min to Max.
+type Param struct {
+	// Name is the name of the train flag.
+	Name string
+
+	// Integer is set for parameters which must be whole
+	// numbers, like batch sizes.
+	Integer bool
+
+	Values []float64
+
+	Min float64
+	Max float64
+
+	// Log, if set, samples the range log-uniformly.
+	Log bool
+}
+
+// ParseParam parses a search space specification.
+//
+// The spec is either a comma-separated list of values
+// (e.g. "0.1,0.01") or a range of the form "min:max",
+// optionally followed by ":log" for log-uniform sampling
+// (e.g. "0.0001:0.1:log").
+func ParseParam(name, spec string, integer bool) (*Param, error) {
+	res := &Param{Name: name, Integer: integer}
+	if strings.Contains(spec, ":") {
+		parts := strings.Split(spec, ":")
+		if len(parts) == 3 && parts[2] == "log" {
+			res.Log = true
+			parts = parts[:2]
+		}
+		if len(parts) != 2 {
+			return nil, errors.New("bad range for -" + name + ": " + spec)
+		}
+		var err error
+		res.Min, err = strconv.ParseFloat(parts[0], 64)
+		if err != nil {
+			return nil, errors.New("bad range for -" + name + ": " + spec)
+		}
+		res.Max, err = strconv.ParseFloat(parts[1], 64)
+		if err != nil || res.Max < res.Min {
+			return nil, errors.New("bad range for -" + name + ": " + spec)
+		}
+		if res.Log && res.Min <= 0 {
+			return nil, errors.New("log range for -" + name + " must be positive")
+		}
+		return res, nil
+	}
+	for _, field := range strings.Split(spec, ",") {
+		value, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return nil, errors.New("bad value for -" + name + ": " + field)
+		}
+		if integer && value != math.Floor(value) {
+			return nil, errors.New("-" + name + " must be an integer")
+		}
+		res.Values = append(res.Values, value)
+	}
+	return res, nil
+}
+
+// IsRange checks if the parameter is a range rather than a
+// list of values.
+func (p *Param) IsRange() bool {
+	return p.Values == nil
+}
+
+// Sample draws a random value for the parameter.
+func (p *Param) Sample(gen *rand.Rand) float64 {
+	if !p.IsRange() {
+		return p.Values[gen.Intn(len(p.Values))]
+	}
+	var res float64
+	if p.Log {
+		res = math.Exp(math.Log(p.Min) + gen.Float64()*(math.Log(p.Max)-math.Log(p.Min)))
+	} else {
+		res = p.Min + gen.Float64()*(p.Max-p.Min)
+	}
+	if p.Integer {
+		res = math.Max(p.Min, math.Min(p.Max, math.Round(res)))
+	}
+	return res
+}
+
+// Format formats a value of the parameter as a flag value.
+func (p *Param) Format(value float64) string {
+	if p.Integer {
+		return strconv.Itoa(int(value))
+	}
+	return strconv.FormatFloat(value, 'g', 4, 64)
+}
+
+// GridTrials lists every combination of parameter values.
+// Each trial has one value per parameter.
+func GridTrials(params []*Param) ([][]float64, error) {
+	res := [][]float64{{}}
+	for _, p := range params {
+		if p.IsRange() {
+			return nil, errors.New("grid search requires lists of values, not ranges (-" +
+				p.Name + ")")
+		}
+		var next [][]float64
+		for _, trial := range res {
+			for _, value := range p.Values {
+				next = append(next, append(append([]float64{}, trial...), value))
+			}
+		}
+		res = next
+	}
+	return res, nil
+}
+
+// RandomTrials draws n random trials.
+// Each trial has one value per parameter.
+func RandomTrials(params []*Param, n int, gen *rand.Rand) [][]float64 {
+	var res [][]float64
+	for i := 0; i < n; i++ {
+		var trial []float64
+		for _, p := range params {
+			trial = append(trial, p.Sample(gen))
+		}
+		res = append(res, trial)
+	}
+	return res
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/unixpickle/essentials"
+	"github.com/unixpickle/imagenet"
+)
+
+// A Trial is one training run in a sweep.
+type Trial struct {
+	Index  int
+	Params []*Param
+	Values []float64
+
+	// Dir is the run directory passed to train.
+	Dir string
+
+	// Err is set if train failed.
+	Err error
+
+	// Best is the validation record with the highest top-1
+	// accuracy, or nil if train never ran validation.
+	Best *imagenet.MetricsRecord
+
+	Duration time.Duration
+}
+
+// Describe formats the trial's parameter values.
+func (t *Trial) Describe() string {
+	var parts []string
+	for i, p := range t.Params {
+		parts = append(parts, p.Name+"="+p.Format(t.Values[i]))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Run runs train for the trial.
+//
+// Training is interrupted (as if by ctrl+c, so that train
+// saves its network) once the duration has elapsed.
+// A duration of 0 means no time limit.
+//
+// If stop is closed, Run waits for train to exit.
+// Since train runs in the same process group, it receives
+// the ctrl+c which closed stop; sending a second interrupt
+// would kill it before it could save.
+func (t *Trial) Run(trainPath string, baseArgs []string, duration time.Duration,
+	stop <-chan struct{}) {
+	start := time.Now()
+	defer func() {
+		t.Duration = time.Since(start)
+	}()
+	if err := os.MkdirAll(t.Dir, 0755); err != nil {
+		t.Err = err
+		return
+	}
+	output, err := os.Create(filepath.Join(t.Dir, "output.log"))
+	if err != nil {
+		t.Err = err
+		return
+	}
+	defer output.Close()
+
+	args := append([]string{}, baseArgs...)
+	args = append(args, "-rundir", t.Dir)
+	for i, p := range t.Params {
+		args = append(args, "-"+p.Name, p.Format(t.Values[i]))
+	}
+	cmd := exec.Command(trainPath, args...)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	if err := cmd.Start(); err != nil {
+		t.Err = err
+		return
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	var timeout <-chan time.Time
+	if duration > 0 {
+		timer := time.NewTimer(duration)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case err = <-exited:
+	case <-timeout:
+		cmd.Process.Signal(os.Interrupt)
+		err = <-exited
+	case <-stop:
+		select {
+		case err = <-exited:
+		case <-timeout:
+			cmd.Process.Signal(os.Interrupt)
+			err = <-exited
+		}
+	}
+	if err != nil {
+		t.Err = fmt.Errorf("train: %v (see %s)", err, output.Name())
+	}
+	t.readResults()
+}
+
+func (t *Trial) readResults() {
+	path := filepath.Join(t.Dir, "metrics.jsonl")
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return
+	}
+	records, err := imagenet.ReadMetrics(path)
+	if err != nil {
+		if t.Err == nil {
+			t.Err = err
+		}
+		return
+	}
+	for _, r := range records {
+		if r.Kind == imagenet.ValidationMetrics && (t.Best == nil || r.Top1 > t.Best.Top1) {
+			t.Best = r
+		}
+	}
+}
+
+// rankTrials sorts trials from best to worst.
+//
+// Trials are ranked by top-1 accuracy, then by validation
+// loss.
+// Trials without validation results come last.
+func rankTrials(trials []*Trial) {
+	sort.SliceStable(trials, func(i, j int) bool {
+		b1, b2 := trials[i].Best, trials[j].Best
+		if b1 == nil || b2 == nil {
+			return b2 == nil && b1 != nil
+		}
+		if b1.Top1 != b2.Top1 {
+			return b1.Top1 > b2.Top1
+		}
+		return b1.ValidationLoss < b2.ValidationLoss
+	})
+}
+
+// writeResults writes a table of ranked trials.
+func writeResults(w io.Writer, params []*Param, trials []*Trial) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	header := []string{"rank", "trial"}
+	for _, p := range params {
+		header = append(header, p.Name)
+	}
+	header = append(header, "top1", "top5", "loss", "iter", "epoch", "minutes", "status")
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for i, t := range trials {
+		row := []string{fmt.Sprint(i + 1), fmt.Sprint(t.Index)}
+		for j, p := range params {
+			row = append(row, p.Format(t.Values[j]))
+		}
+		if t.Best != nil {
+			row = append(row,
+				fmt.Sprintf("%.4f", t.Best.Top1),
+				fmt.Sprintf("%.4f", t.Best.Top5),
+				fmt.Sprintf("%.4f", t.Best.ValidationLoss),
+				fmt.Sprint(t.Best.Iter),
+				fmt.Sprintf("%.2f", t.Best.Epoch))
+		} else {
+			row = append(row, "-", "-", "-", "-", "-")
+		}
+		row = append(row, fmt.Sprintf("%.1f", t.Duration.Minutes()))
+		switch {
+		case t.Err != nil:
+			row = append(row, "failed")
+		case t.Best == nil:
+			row = append(row, "no validation")
+		default:
+			row = append(row, "ok")
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// makeClassSubset creates a sample directory with some of
+// the classes from sampleDir.
+//
+// Class directories are created for real (since sample
+// lists skip links to directories), but each image is a
+// symbolic link to the original file.
+func makeClassSubset(sampleDir, outDir string, classes []string) error {
+	if err := os.RemoveAll(outDir); err != nil {
+		return essentials.AddCtx("make class subset", err)
+	}
+	absDir, err := filepath.Abs(sampleDir)
+	if err != nil {
+		return essentials.AddCtx("make class subset", err)
+	}
+	for _, class := range classes {
+		classDir := filepath.Join(outDir, class)
+		if err := os.MkdirAll(classDir, 0755); err != nil {
+			return essentials.AddCtx("make class subset", err)
+		}
+		listing, err := ioutil.ReadDir(filepath.Join(absDir, class))
+		if err != nil {
+			return essentials.AddCtx("make class subset", err)
+		}
+		for _, item := range listing {
+			if strings.HasPrefix(item.Name(), ".") {
+				continue
+			}
+			source := filepath.Join(absDir, class, item.Name())
+			if err := os.Symlink(source, filepath.Join(classDir, item.Name())); err != nil {
+				return essentials.AddCtx("make class subset", err)
+			}
+		}
+	}
+	return nil
+}
+
+// listClasses lists the class directories in a sample
+// directory.
+func listClasses(sampleDir string) ([]string, error) {
+	listing, err := ioutil.ReadDir(sampleDir)
+	if err != nil {
+		return nil, err
+	}
+	var res []string
+	for _, item := range listing {
+		if item.IsDir() {
+			res = append(res, item.Name())
+		}
+	}
+	sort.Strings(res)
+	return res, nil
+}
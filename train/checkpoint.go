@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/unixpickle/essentials"
+	"github.com/unixpickle/imagenet"
+	"github.com/unixpickle/serializer"
+)
+
+// A Checkpointer periodically saves checkpoints of a
+// network during training.
+//
+// Checkpoints are saved next to the output file, with an
+// added suffix indicating the iteration (e.g.
+// "out_net.ckpt-000001000").
+type Checkpointer struct {
+	// OutPath is the path of the main output file.
+	OutPath string
+
+	// Iters is the number of iterations between
+	// checkpoints, or 0 to disable iteration-based saving.
+	Iters int
+
+	// Interval is the time between checkpoints, or 0 to
+	// disable time-based saving.
+	Interval time.Duration
+
+	// Keep is the number of checkpoints to retain.
+	// Older checkpoints are deleted.
+	// If it is 0, all checkpoints are kept.
+	Keep int
+
+	lastIter int
+	lastTime time.Time
+}
+
+// Due checks if a checkpoint should be saved at the given
+// iteration.
+func (c *Checkpointer) Due(iter int) bool {
+	if c.lastTime.IsZero() {
+		c.lastTime = time.Now()
+		c.lastIter = iter
+	}
+	if c.Iters > 0 && iter-c.lastIter >= c.Iters {
+		return true
+	}
+	return c.Interval > 0 && time.Since(c.lastTime) >= c.Interval
+}
+
+// Save saves a checkpoint and its training state for the
+// given iteration and removes old checkpoints.
+func (c *Checkpointer) Save(iter int, cl *imagenet.Classifier, state *TrainingState) error {
+	c.lastIter = iter
+	c.lastTime = time.Now()
+	if err := SaveClassifier(c.path(iter), cl); err != nil {
+		return essentials.AddCtx("save checkpoint", err)
+	}
+	if err := state.Save(c.path(iter)); err != nil {
+		return essentials.AddCtx("save checkpoint", err)
+	}
+	if c.Keep > 0 {
+		paths, err := c.Checkpoints()
+		if err != nil {
+			return essentials.AddCtx("save checkpoint", err)
+		}
+		for len(paths) > c.Keep {
+			if err := os.Remove(paths[0]); err != nil {
+				return essentials.AddCtx("save checkpoint", err)
+			}
+			os.Remove(StatePath(paths[0]))
+			paths = paths[1:]
+		}
+	}
+	return nil
+}
+
+// Checkpoints lists the existing checkpoint files, from
+// oldest to newest.
+func (c *Checkpointer) Checkpoints() ([]string, error) {
+	paths, err := filepath.Glob(c.OutPath + ".ckpt-*")
+	if err != nil {
+		return nil, err
+	}
+	var res []string
+	for _, p := range paths {
+		if ext := filepath.Ext(p); ext != ".tmp" && ext != ".state" {
+			res = append(res, p)
+		}
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// ResolveResume turns the argument of the -resume flag
+// into a file path.
+// The argument "latest" refers to the newest checkpoint.
+func (c *Checkpointer) ResolveResume(arg string) (string, error) {
+	if arg != "latest" {
+		return arg, nil
+	}
+	paths, err := c.Checkpoints()
+	if err != nil {
+		return "", err
+	} else if len(paths) == 0 {
+		return "", errors.New("no checkpoints found for " + c.OutPath)
+	}
+	return paths[len(paths)-1], nil
+}
+
+func (c *Checkpointer) path(iter int) string {
+	return fmt.Sprintf("%s.ckpt-%09d", c.OutPath, iter)
+}
+
+// SaveClassifier saves a classifier with SaveAtomic,
+// unless its parameters are not finite, in which case the
+// existing file is left alone and an error is returned.
+func SaveClassifier(path string, cl *imagenet.Classifier) error {
+	if !ParamsFinite(cl.Net.Parameters()) {
+		return errors.New("refusing to save non-finite network to " + path)
+	}
+	return SaveAtomic(path, cl)
+}
+
+// SaveAtomic serializes an object and saves it to a file.
+//
+// The data is written to a temporary file and then renamed
+// to the final path, so the file at path is always either
+// the old version or the complete new version.
+func SaveAtomic(path string, obj serializer.Serializer) error {
+	data, err := serializer.SerializeAny(obj)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/unixpickle/essentials"
+	"github.com/unixpickle/imagenet"
+)
+
+// A Config stores the settings which determine how a
+// network is trained.
+//
+// Every field can be set with a command-line flag or
+// loaded from a JSON config file.
+// The resolved config is saved with the trained network,
+// and its hash is stored in the classifier.
+type Config struct {
+	Data         DataConfig
+	Model        ModelConfig
+	Optimizer    OptimizerConfig
+	Schedule     ScheduleConfig
+	Augmentation imagenet.Augmentation
+	Loss         LossConfig
+	Resize       ResizeConfig
+	Stop         StopConfig
+
+	// Seed is the root of every random choice made during
+	// training, or 0 to choose a seed based on the time.
+	Seed int64
+}
+
+// DataConfig specifies the samples used for training and
+// validation.
+type DataConfig struct {
+	Samples            string
+	ValidationSamples  string
+	ValidationFraction float64
+	Split              string
+	SplitList          string
+	BadSamples         string
+}
+
+// ModelConfig specifies the network to create when no
+// network exists yet, and which of its layers to train.
+type ModelConfig struct {
+	Markup string
+
+	// FitOutput, if set, replaces the last fully-connected
+	// layer of a new Markup network if it does not have one
+	// output per class.
+	// Otherwise, such a mismatch is an error.
+	FitOutput bool
+
+	// FineTune, if set, is the path to a pre-trained
+	// classifier to use instead of Markup.
+	// Its last DropLayers layers are replaced with a new
+	// fully-connected layer and softmax.
+	FineTune   string
+	DropLayers int
+
+	// TrainLayers, if non-zero, is the number of top-level
+	// layers at the end of the network to train.
+	// All other layers are frozen.
+	TrainLayers int
+}
+
+// OptimizerConfig specifies the optimizer and its
+// hyper-parameters.
+type OptimizerConfig struct {
+	// Kind is "adam", "momentum", "nesterov", "rmsprop",
+	// "lars", or "lamb".
+	// If it is empty, the optimizer is taken from the saved
+	// training state, or else it is "momentum" if Momentum
+	// is set and "adam" otherwise.
+	Kind string
+
+	Step       float64
+	Batch      int
+	BatchBatch int
+	Decay      float64
+	Momentum   float64
+
+	// Trust is the trust coefficient for "lars".
+	Trust float64
+
+	// DecoupledDecay applies weight decay directly to the
+	// parameters instead of adding an L2 penalty to the
+	// cost.
+	DecoupledDecay bool
+
+	// NoDecay lists parameter selectors which are excluded
+	// from weight decay (e.g. "norm,bias").
+	NoDecay string
+
+	// RateScales lists learning rate multipliers for
+	// parameter selectors (e.g. "0-3=0.1,bias=2").
+	RateScales string
+
+	// ClipNorm and ClipValue configure gradient clipping.
+	// See Guard.
+	ClipNorm  float64
+	ClipValue float64
+
+	// NonFinite is the response to a non-finite loss or
+	// gradient ("skip", "lower", "rollback", or "abort").
+	NonFinite string
+
+	// EMADecay, if non-zero, is the decay rate of an
+	// exponential moving average of the weights, which is
+	// used for validation and saved as the output network.
+	EMADecay float64
+}
+
+// ScheduleConfig specifies the learning rate schedule.
+// See Schedule for the meaning of each field.
+type ScheduleConfig struct {
+	Kind         string
+	Gamma        float64
+	StepEpochs   float64
+	Milestones   []float64
+	TotalEpochs  float64
+	MinRate      float64
+	WarmupEpochs float64
+	Patience     int
+}
+
+// Schedule creates a Schedule starting at the given rate.
+func (s *ScheduleConfig) Schedule(base float64) *Schedule {
+	return &Schedule{
+		Kind:         s.Kind,
+		Base:         base,
+		Gamma:        s.Gamma,
+		StepEpochs:   s.StepEpochs,
+		Milestones:   s.Milestones,
+		TotalEpochs:  s.TotalEpochs,
+		MinRate:      s.MinRate,
+		WarmupEpochs: s.WarmupEpochs,
+		Patience:     s.Patience,
+	}
+}
+
+// ResizeConfig specifies progressive resizing.
+// See ResizeSchedule for the meaning of each field.
+type ResizeConfig struct {
+	Sizes      []int
+	Epochs     []int
+	ScaleBatch bool
+}
+
+// Schedule creates a ResizeSchedule which ends at the
+// given input size.
+// It returns nil if there is no progressive resizing.
+func (r *ResizeConfig) Schedule(finalSize int) *ResizeSchedule {
+	if len(r.Sizes) == 0 && len(r.Epochs) == 0 {
+		return nil
+	}
+	return &ResizeSchedule{
+		Sizes:      r.Sizes,
+		Epochs:     r.Epochs,
+		FinalSize:  finalSize,
+		ScaleBatch: r.ScaleBatch,
+	}
+}
+
+// StopConfig specifies when training ends on its own.
+// See Stopper for the meaning of each field.
+type StopConfig struct {
+	MaxEpochs  int
+	MaxIters   int
+	MaxMinutes float64
+	TargetTop1 float64
+	Patience   int
+	MinDelta   float64
+}
+
+// Stopper creates a Stopper with the given state.
+func (s *StopConfig) Stopper(state StopState) *Stopper {
+	return &Stopper{
+		MaxEpochs:  s.MaxEpochs,
+		MaxIters:   s.MaxIters,
+		MaxTime:    time.Duration(s.MaxMinutes * float64(time.Minute)),
+		TargetTop1: s.TargetTop1,
+		Patience:   s.Patience,
+		MinDelta:   s.MinDelta,
+		State:      state,
+	}
+}
+
+// LoadConfigFile reads a JSON config file into c.
+//
+// Fields missing from the file are left unchanged, and
+// flags which were passed explicitly take precedence over
+// the file.
+func LoadConfigFile(path string, c *Config) error {
+	explicit := map[string]string{}
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = f.Value.String()
+	})
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return essentials.AddCtx("load config", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return essentials.AddCtx("load config "+path, err)
+	}
+	for name, value := range explicit {
+		if err := flag.Set(name, value); err != nil {
+			return essentials.AddCtx("load config", err)
+		}
+	}
+	return nil
+}
+
+// Save writes the config to a JSON file.
+func (c *Config) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return essentials.AddCtx("save config", err)
+	}
+	if err := writeFileAtomic(path, append(data, '\n')); err != nil {
+		return essentials.AddCtx("save config", err)
+	}
+	return nil
+}
+
+// Hash computes a hexadecimal hash of the config.
+func (c *Config) Hash() string {
+	data, err := json.Marshal(c)
+	if err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
+
+// A RunDir is a directory which holds everything about a
+// training run: the resolved config, a copy of the model
+// markup, the network and its checkpoints, the metrics,
+// and the log.
+type RunDir string
+
+// ConfigPath returns the path of the resolved config.
+func (r RunDir) ConfigPath() string {
+	return filepath.Join(string(r), "config.json")
+}
+
+// ModelPath returns the path of the model markup.
+func (r RunDir) ModelPath() string {
+	return filepath.Join(string(r), "model.txt")
+}
+
+// NetPath returns the path of the network file.
+// Checkpoints and training state are stored next to it.
+func (r RunDir) NetPath() string {
+	return filepath.Join(string(r), "net")
+}
+
+// SplitPath returns the path of the validation list.
+func (r RunDir) SplitPath() string {
+	return filepath.Join(string(r), "validation.split")
+}
+
+// MetricsPath returns the path of the metrics file.
+func (r RunDir) MetricsPath() string {
+	return filepath.Join(string(r), "metrics.jsonl")
+}
+
+// LogPath returns the path of the training log.
+func (r RunDir) LogPath() string {
+	return filepath.Join(string(r), "train.log")
+}
+
+// floatList is a flag.Value for a comma-separated list of
+// numbers.
+type floatList []float64
+
+func (f *floatList) String() string {
+	if f == nil {
+		return ""
+	}
+	var parts []string
+	for _, x := range *f {
+		parts = append(parts, strconv.FormatFloat(x, 'g', -1, 64))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f *floatList) Set(str string) error {
+	list, err := parseMilestones(str)
+	if err != nil {
+		return err
+	}
+	*f = list
+	return nil
+}
+
+// intList is a flag.Value for a comma-separated list of
+// integers.
+type intList []int
+
+func (i *intList) String() string {
+	if i == nil {
+		return ""
+	}
+	var parts []string
+	for _, x := range *i {
+		parts = append(parts, strconv.Itoa(x))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (i *intList) Set(str string) error {
+	var list []int
+	for _, part := range strings.Split(str, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		x, err := strconv.Atoi(part)
+		if err != nil {
+			return errors.New("invalid integer list: " + str)
+		}
+		list = append(list, x)
+	}
+	*i = list
+	return nil
+}
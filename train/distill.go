@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/unixpickle/anydiff"
+	"github.com/unixpickle/anynet"
+	"github.com/unixpickle/anynet/anyff"
+	"github.com/unixpickle/anynet/anysgd"
+	"github.com/unixpickle/anyvec"
+	"github.com/unixpickle/imagenet"
+	"github.com/unixpickle/serializer"
+)
+
+// DistillCost mixes a hard-label cost with the KL
+// divergence between a teacher's and the student's
+// outputs, both softened by a temperature
+// (https://arxiv.org/abs/1503.02531).
+//
+// The KL term is only included while Teacher is set, so
+// the cost reduces to the hard-label cost for validation.
+type DistillCost struct {
+	Hard anynet.Cost
+
+	// Weight is the weight of the KL term, between 0 and 1.
+	// The hard-label cost is weighted by 1-Weight.
+	Weight float64
+
+	Temperature float64
+
+	// Teacher stores the teacher's log probabilities for
+	// the current batch.
+	Teacher anyvec.Vector
+}
+
+// Cost computes the cost for each sample.
+//
+// The KL term is scaled by the squared temperature, so
+// its gradients have the same magnitude regardless of
+// the temperature.
+func (d *DistillCost) Cost(desired, actual anydiff.Res, n int) anydiff.Res {
+	hard := d.Hard.Cost(desired, actual, n)
+	if d.Teacher == nil {
+		return hard
+	}
+	c := actual.Output().Creator()
+	numClasses := actual.Output().Len() / n
+	teacherLog := anydiff.LogSoftmax(
+		anydiff.Scale(anydiff.NewConst(d.Teacher), c.MakeNumeric(1/d.Temperature)),
+		numClasses,
+	).Output()
+	teacherProbs := teacherLog.Copy()
+	anyvec.Exp(teacherProbs)
+
+	soft := &SoftCost{Temperature: d.Temperature}
+	crossEntropy := soft.Cost(anydiff.NewConst(teacherProbs), actual, n)
+	entropy := anynet.DotCost{}.Cost(anydiff.NewConst(teacherProbs),
+		anydiff.NewConst(teacherLog), n)
+	kl := anydiff.Sub(crossEntropy, entropy)
+
+	tempSq := d.Temperature * d.Temperature
+	return anydiff.Add(
+		anydiff.Scale(hard, c.MakeNumeric(1-d.Weight)),
+		anydiff.Scale(kl, c.MakeNumeric(d.Weight*tempSq)),
+	)
+}
+
+// A Distiller is an anysgd.Gradienter which trains a
+// student network to mimic a teacher classifier.
+//
+// The Trainer's cost must use Cost, so that it can see
+// the teacher's outputs for each batch.
+type Distiller struct {
+	Trainer *anyff.Trainer
+	Teacher *imagenet.Classifier
+	Cost    *DistillCost
+}
+
+// Gradient computes the gradient of the student for a
+// batch, using the teacher's outputs on the same inputs.
+func (d *Distiller) Gradient(b anysgd.Batch) anydiff.Grad {
+	batch := b.(*anyff.Batch)
+	d.Cost.Teacher = d.Teacher.Net.Apply(batch.Inputs, batch.Num).Output()
+	defer func() {
+		d.Cost.Teacher = nil
+	}()
+	return d.Trainer.Gradient(b)
+}
+
+// LoadTeacher loads a teacher classifier and checks that
+// it is compatible with the student.
+func LoadTeacher(path string, student *imagenet.Classifier) (*imagenet.Classifier, error) {
+	var teacher *imagenet.Classifier
+	if err := serializer.LoadAny(path, &teacher); err != nil {
+		return nil, err
+	}
+	if teacher.InWidth != student.InWidth || teacher.InHeight != student.InHeight {
+		return nil, errors.New("teacher input size does not match student")
+	}
+	if len(teacher.Classes) != len(student.Classes) {
+		return nil, errors.New("teacher classes do not match student")
+	}
+	for i, class := range teacher.Classes {
+		if class != student.Classes[i] {
+			return nil, errors.New("teacher classes do not match student")
+		}
+	}
+	return teacher, nil
+}
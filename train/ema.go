@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"math"
+
+	"github.com/unixpickle/anydiff"
+	"github.com/unixpickle/anyvec"
+)
+
+// An EMA maintains an exponential moving average of a set
+// of parameters.
+type EMA struct {
+	// Decay is the decay rate of the average.
+	// During the first steps, a smaller decay is used so
+	// that the average is not dominated by the initial
+	// parameters.
+	Decay float64
+
+	Params []*anydiff.Var
+
+	steps   int
+	average map[*anydiff.Var]anyvec.Vector
+}
+
+// EMAState is the serializable state of an EMA.
+type EMAState struct {
+	Steps   int
+	Average [][]float32
+}
+
+// NewEMA creates an EMA which starts at the current
+// parameters.
+func NewEMA(params []*anydiff.Var, decay float64) *EMA {
+	res := &EMA{
+		Decay:   decay,
+		Params:  params,
+		average: map[*anydiff.Var]anyvec.Vector{},
+	}
+	for _, p := range params {
+		res.average[p] = p.Vector.Copy()
+	}
+	return res
+}
+
+// Update adds the current parameters to the average.
+func (e *EMA) Update() {
+	e.steps++
+	decay := math.Min(e.Decay, float64(1+e.steps)/float64(10+e.steps))
+	for _, p := range e.Params {
+		avg := e.average[p]
+		c := avg.Creator()
+		avg.Scale(c.MakeNumeric(decay))
+		scaled := p.Vector.Copy()
+		scaled.Scale(c.MakeNumeric(1 - decay))
+		avg.Add(scaled)
+	}
+}
+
+// Swap exchanges the parameters with their averages.
+// Calling Swap twice restores the original parameters.
+func (e *EMA) Swap() {
+	for _, p := range e.Params {
+		avg := e.average[p]
+		tmp := p.Vector.Copy()
+		p.Vector.Set(avg)
+		avg.Set(tmp)
+	}
+}
+
+// SaveState exports the average.
+func (e *EMA) SaveState() *EMAState {
+	return &EMAState{
+		Steps:   e.steps,
+		Average: saveBuffer(e.Params, e.average),
+	}
+}
+
+// LoadState imports an average saved by SaveState.
+func (e *EMA) LoadState(state *EMAState) error {
+	average, err := loadBuffer(e.Params, state.Average)
+	if err != nil {
+		return err
+	}
+	for _, p := range e.Params {
+		if _, ok := average[p]; !ok {
+			return errors.New("load EMA: missing average")
+		}
+	}
+	e.steps, e.average = state.Steps, average
+	return nil
+}
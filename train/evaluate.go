@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/unixpickle/anynet/anyff"
+	"github.com/unixpickle/anyvec"
+	"github.com/unixpickle/imagenet"
+)
+
+// evaluateBatch computes the mean cost of a batch and the
+// number of samples for which the correct class is the
+// top guess and in the top five guesses.
+func evaluateBatch(t *anyff.Trainer, b *anyff.Batch) (cost float64, top1, top5 int) {
+	out := t.Net.Apply(b.Inputs, b.Num)
+	costSum := anyvec.Sum(t.Cost.Cost(b.Outputs, out, b.Num).Output())
+	cost = numToFloat(costSum) / float64(b.Num)
+
+	actual := out.Output().Data().([]float32)
+	expected := b.Outputs.Output().Data().([]float32)
+	numClasses := len(actual) / b.Num
+	for i := 0; i < b.Num; i++ {
+		scores := actual[i*numClasses : (i+1)*numClasses]
+		target := maxIndex(expected[i*numClasses : (i+1)*numClasses])
+		var rank int
+		for _, score := range scores {
+			if score > scores[target] {
+				rank++
+			}
+		}
+		if rank < 1 {
+			top1++
+		}
+		if rank < 5 {
+			top5++
+		}
+	}
+	return
+}
+
+func maxIndex(vals []float32) int {
+	var idx int
+	for i, x := range vals {
+		if x > vals[idx] {
+			idx = i
+		}
+	}
+	return idx
+}
+
+func numToFloat(n anyvec.Numeric) float64 {
+	switch n := n.(type) {
+	case float32:
+		return float64(n)
+	case float64:
+		return n
+	default:
+		panic("unsupported numeric type")
+	}
+}
+
+// A ValidationResult summarizes a pass over validation
+// samples.
+type ValidationResult struct {
+	Num  int
+	Loss float64
+	Top1 float64
+	Top5 float64
+}
+
+// validate evaluates the network on every sample in the
+// list and averages the results.
+func validate(t *anyff.Trainer, l *imagenet.Loader, samples imagenet.SampleList,
+	batchSize int) (*ValidationResult, error) {
+	var costSum float64
+	var top1, top5, num int
+	addBatch := func(b *anyff.Batch) {
+		cost, correct1, correct5 := evaluateBatch(t, b)
+		costSum += cost * float64(b.Num)
+		top1 += correct1
+		top5 += correct5
+		num += b.Num
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	for loaded := range l.Load(samples, batchSize, 0, 0, done) {
+		if loaded.Err != nil {
+			return nil, loaded.Err
+		}
+		addBatch(loaded.Batch.(*anyff.Batch))
+	}
+	if rem := len(samples) % batchSize; rem != 0 {
+		batch, err := l.Fetcher.Fetch(samples[len(samples)-rem:])
+		if err != nil {
+			return nil, err
+		}
+		addBatch(batch.(*anyff.Batch))
+	}
+
+	if num == 0 {
+		return nil, errors.New("validate: no samples")
+	}
+	return &ValidationResult{
+		Num:  num,
+		Loss: costSum / float64(num),
+		Top1: float64(top1) / float64(num),
+		Top5: float64(top5) / float64(num),
+	}, nil
+}
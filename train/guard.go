@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"math"
+
+	"github.com/unixpickle/anydiff"
+)
+
+// ErrRollback is returned by SGD.Run when a non-finite
+// step calls for a roll back to the last checkpoint.
+var ErrRollback = errors.New("roll back to last checkpoint")
+
+// Responses to non-finite losses or gradients.
+const (
+	SkipNonFinite      = "skip"
+	LowerRateNonFinite = "lower"
+	RollbackNonFinite  = "rollback"
+	AbortNonFinite     = "abort"
+)
+
+// A Guard clips gradients and decides what to do about
+// non-finite losses and gradients.
+type Guard struct {
+	// ClipNorm, if non-zero, is the maximum global norm of
+	// the gradient.
+	// Larger gradients are scaled down to this norm.
+	ClipNorm float64
+
+	// ClipValue, if non-zero, is the maximum absolute value
+	// of any gradient component.
+	ClipValue float64
+
+	// Response is the response to a non-finite step: skip
+	// the step, lower the learning rate (and skip the
+	// step), roll back to the last checkpoint, or abort
+	// training.
+	Response string
+
+	// LossFunc, if non-nil, returns the loss of the last
+	// batch, so it can be checked along with the gradient.
+	LossFunc func() float64
+}
+
+// ValidateResponse checks that the response is known.
+func (g *Guard) ValidateResponse() error {
+	switch g.Response {
+	case SkipNonFinite, LowerRateNonFinite, RollbackNonFinite, AbortNonFinite:
+		return nil
+	}
+	return errors.New("unknown non-finite response: " + g.Response)
+}
+
+// Finite checks if the loss and gradient are finite.
+func (g *Guard) Finite(grad anydiff.Grad) bool {
+	if g.LossFunc != nil && !isFinite(g.LossFunc()) {
+		return false
+	}
+	return isFinite(gradNorm(grad))
+}
+
+// Clip clips the gradient in place.
+func (g *Guard) Clip(grad anydiff.Grad) {
+	if g.ClipValue > 0 {
+		max := float32(g.ClipValue)
+		for _, vec := range grad {
+			data := vec.Data().([]float32)
+			for i, x := range data {
+				if x > max {
+					data[i] = max
+				} else if x < -max {
+					data[i] = -max
+				}
+			}
+			vec.SetData(data)
+		}
+	}
+	if g.ClipNorm > 0 {
+		if norm := gradNorm(grad); norm > g.ClipNorm {
+			grad.Scale(scalar(grad, g.ClipNorm/norm))
+		}
+	}
+}
+
+// ParamsFinite checks that every parameter is finite.
+func ParamsFinite(params []*anydiff.Var) bool {
+	for _, p := range params {
+		if !isFinite(numToFloat(p.Vector.Dot(p.Vector))) {
+			return false
+		}
+	}
+	return true
+}
+
+func gradNorm(grad anydiff.Grad) float64 {
+	var sum float64
+	for _, vec := range grad {
+		sum += numToFloat(vec.Dot(vec))
+	}
+	return math.Sqrt(sum)
+}
+
+func isFinite(x float64) bool {
+	return !math.IsNaN(x) && !math.IsInf(x, 0)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/unixpickle/anydiff"
+	"github.com/unixpickle/anynet"
+	"github.com/unixpickle/anyvec"
+	"github.com/unixpickle/essentials"
+	"github.com/unixpickle/imagenet"
+)
+
+// LossConfig specifies the loss function.
+type LossConfig struct {
+	// Kind is one of "ce", "smooth", "focal", "weighted",
+	// or "soft".
+	Kind string
+
+	// Smoothing is the label smoothing factor for "smooth".
+	Smoothing float64
+
+	// FocalGamma is the focusing parameter for "focal".
+	FocalGamma float64
+
+	// ClassWeights is either "balanced" or the path to a
+	// file of "class weight" lines, for "weighted".
+	ClassWeights string
+
+	// Temperature is the softmax temperature for "soft".
+	Temperature float64
+
+	// Teacher, if set, is the path to a classifier to
+	// distill into the network.
+	// The loss above is mixed with a distillation loss
+	// with the given weight and temperature.
+	// See DistillCost.
+	Teacher            string
+	DistillWeight      float64
+	DistillTemperature float64
+}
+
+// Cost creates the loss function.
+//
+// The classes and training samples are used to compute
+// class weights.
+func (l *LossConfig) Cost(classes []string, training imagenet.SampleList) (anynet.Cost,
+	error) {
+	switch l.Kind {
+	case "ce":
+		return anynet.DotCost{}, nil
+	case "smooth":
+		if l.Smoothing < 0 || l.Smoothing >= 1 {
+			return nil, errors.New("label smoothing must be in [0, 1)")
+		}
+		return &SmoothedCost{Smoothing: l.Smoothing}, nil
+	case "focal":
+		if l.FocalGamma < 0 {
+			return nil, errors.New("focal gamma must be non-negative")
+		}
+		return &FocalCost{Gamma: l.FocalGamma}, nil
+	case "weighted":
+		weights, err := classWeights(l.ClassWeights, classes, training)
+		if err != nil {
+			return nil, err
+		}
+		return &WeightedCost{Weights: weights}, nil
+	case "soft":
+		if l.Temperature <= 0 {
+			return nil, errors.New("temperature must be positive")
+		}
+		return &SoftCost{Temperature: l.Temperature}, nil
+	default:
+		return nil, errors.New("unknown loss: " + l.Kind)
+	}
+}
+
+// SmoothedCost is cross-entropy with label smoothing.
+//
+// The desired distribution is mixed with a uniform
+// distribution, giving the uniform distribution a weight
+// of Smoothing.
+type SmoothedCost struct {
+	Smoothing float64
+}
+
+// Cost computes the cost for each sample.
+func (s *SmoothedCost) Cost(desired, actual anydiff.Res, n int) anydiff.Res {
+	d := desired.Output().Copy()
+	c := d.Creator()
+	numClasses := d.Len() / n
+	d.Scale(c.MakeNumeric(1 - s.Smoothing))
+	d.AddScalar(c.MakeNumeric(s.Smoothing / float64(numClasses)))
+	return anynet.DotCost{}.Cost(anydiff.NewConst(d), actual, n)
+}
+
+// FocalCost implements the focal loss from
+// https://arxiv.org/abs/1708.02002, which down-weights
+// samples that are already classified confidently.
+//
+// The actual outputs must be log probabilities.
+type FocalCost struct {
+	Gamma float64
+}
+
+// Cost computes the cost for each sample.
+func (f *FocalCost) Cost(desired, actual anydiff.Res, n int) anydiff.Res {
+	c := actual.Output().Creator()
+	modulator := anydiff.Pow(anydiff.Complement(anydiff.Exp(actual)), c.MakeNumeric(f.Gamma))
+	return anynet.DotCost{}.Cost(desired, anydiff.Mul(modulator, actual), n)
+}
+
+// WeightedCost is cross-entropy where every class has a
+// weight by which its samples' costs are scaled.
+type WeightedCost struct {
+	Weights []float64
+}
+
+// Cost computes the cost for each sample.
+func (w *WeightedCost) Cost(desired, actual anydiff.Res, n int) anydiff.Res {
+	d := desired.Output().Copy()
+	c := d.Creator()
+	anyvec.ScaleRepeated(d, c.MakeVectorData(c.MakeNumericList(w.Weights)))
+	return anynet.DotCost{}.Cost(anydiff.NewConst(d), actual, n)
+}
+
+// SoftCost is cross-entropy between soft target
+// distributions (e.g. from a teacher network or mixup)
+// and the actual outputs softened by a temperature.
+//
+// The actual outputs must be log probabilities (or
+// logits).
+// With a Temperature of 1, it is equivalent to
+// anynet.DotCost.
+type SoftCost struct {
+	Temperature float64
+}
+
+// Cost computes the cost for each sample.
+func (s *SoftCost) Cost(desired, actual anydiff.Res, n int) anydiff.Res {
+	c := actual.Output().Creator()
+	scaled := anydiff.Scale(actual, c.MakeNumeric(1/s.Temperature))
+	logProbs := anydiff.LogSoftmax(scaled, actual.Output().Len()/n)
+	return anynet.DotCost{}.Cost(desired, logProbs, n)
+}
+
+// classWeights computes per-class loss weights.
+//
+// The "balanced" spec weights classes inversely to their
+// frequency in the training set.
+// Any other spec is the path to a file where each line
+// has a class name and a weight; classes which are not
+// listed get a weight of 1.
+func classWeights(spec string, classes []string, training imagenet.SampleList) ([]float64,
+	error) {
+	res := make([]float64, len(classes))
+	if spec == "balanced" {
+		counts := make([]int, len(classes))
+		for _, s := range training {
+			counts[s.Class]++
+		}
+		for i, count := range counts {
+			if count > 0 {
+				res[i] = float64(len(training)) / float64(len(classes)*count)
+			}
+		}
+		return res, nil
+	} else if spec == "" {
+		return nil, errors.New("weighted loss requires class weights")
+	}
+
+	indices := map[string]int{}
+	for i, class := range classes {
+		indices[class] = i
+		res[i] = 1
+	}
+	f, err := os.Open(spec)
+	if err != nil {
+		return nil, essentials.AddCtx("read class weights", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		} else if len(fields) != 2 {
+			return nil, errors.New("read class weights: bad line: " + scanner.Text())
+		}
+		idx, ok := indices[fields[0]]
+		if !ok {
+			return nil, errors.New("read class weights: unknown class: " + fields[0])
+		}
+		res[idx], err = strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, essentials.AddCtx("read class weights", err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, essentials.AddCtx("read class weights", err)
+	}
+	return res, nil
+}
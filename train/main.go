@@ -1,66 +1,395 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"math/rand"
 	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/unixpickle/anynet"
 	"github.com/unixpickle/anynet/anyconv"
 	"github.com/unixpickle/anynet/anyff"
 	"github.com/unixpickle/anynet/anysgd"
-	"github.com/unixpickle/anyvec"
 	"github.com/unixpickle/essentials"
 	"github.com/unixpickle/imagenet"
+	"github.com/unixpickle/imagenet/backend"
 	"github.com/unixpickle/rip"
 	"github.com/unixpickle/serializer"
 )
 
 func main() {
-	rand.Seed(time.Now().UnixNano())
-
-	var imageDir string
+	var cfg Config
+	var configPath string
+	var runDir string
 	var outNet string
-	var stepSize float64
-	var batchSize int
-	var batchBatch int
-	var validationSize float64
-	var weightDecay float64
-	var momentum float64
 	var logInterval int
-	var modelFile string
+	var validInterval int
+	var validSubset int
+	var metricsPath string
+	var workers int
+	var prefetch int
+	var ckptIters int
+	var ckptMinutes float64
+	var ckptKeep int
+	var resume string
+	var saveRaw bool
+	var replicas int
+	var replicaNet string
+	var workerAddr string
 
-	flag.StringVar(&imageDir, "samples", "", "sample directory")
+	flag.StringVar(&configPath, "config", "",
+		"JSON config file (explicit flags take precedence)")
+	flag.StringVar(&runDir, "rundir", "",
+		"run directory for the config, network, checkpoints, and logs")
+	flag.StringVar(&cfg.Data.Samples, "samples", "", "sample directory")
 	flag.StringVar(&outNet, "out", "out_net", "network file")
-	flag.Float64Var(&stepSize, "step", 0.001, "step size")
-	flag.IntVar(&batchSize, "batch", 12, "batch size")
-	flag.IntVar(&batchBatch, "batchbatch", 1, "mini-batches per SGD step")
-	flag.Float64Var(&validationSize, "validation", 0.1, "validation fraction")
-	flag.Float64Var(&weightDecay, "decay", 1e-4, "L2 weight decay")
-	flag.Float64Var(&momentum, "momentum", 0, "SGD momentum (disables Adam)")
+	flag.Float64Var(&cfg.Optimizer.Step, "step", 0.001, "step size")
+	flag.IntVar(&cfg.Optimizer.Batch, "batch", 12, "batch size")
+	flag.IntVar(&cfg.Optimizer.BatchBatch, "batchbatch", 1, "mini-batches per SGD step")
+	flag.Float64Var(&cfg.Data.ValidationFraction, "validation", 0.1, "validation fraction")
+	flag.Float64Var(&cfg.Optimizer.Decay, "decay", 1e-4, "L2 weight decay")
+	flag.StringVar(&cfg.Optimizer.Kind, "optimizer", "",
+		"optimizer (adam, momentum, nesterov, rmsprop, lars, or lamb)")
+	flag.Float64Var(&cfg.Optimizer.Momentum, "momentum", 0,
+		"momentum for momentum, nesterov, and lars (selects momentum by default)")
+	flag.Float64Var(&cfg.Optimizer.Trust, "trust", 0.001, "trust coefficient for lars")
+	flag.BoolVar(&cfg.Optimizer.DecoupledDecay, "decoupled", false,
+		"apply weight decay directly to the weights (AdamW-style)")
+	flag.StringVar(&cfg.Optimizer.NoDecay, "nodecay", "",
+		"comma-separated parameters without weight decay (e.g. \"norm,bias\")")
+	flag.StringVar(&cfg.Optimizer.RateScales, "lrmult", "",
+		"comma-separated learning rate multipliers (e.g. \"0-3=0.1,bias=2\")")
 	flag.IntVar(&logInterval, "logint", 4, "validation log interval")
-	flag.StringVar(&modelFile, "model", "models/orig.txt", "model markup file")
+	flag.IntVar(&validInterval, "valint", 0,
+		"iterations between full validation passes (0 for once per epoch)")
+	flag.IntVar(&validSubset, "valsubset", 0,
+		"number of samples in full validation passes (0 for all)")
+	flag.StringVar(&metricsPath, "metrics", "",
+		"metrics file, .jsonl or .csv (default: network file + \".metrics.jsonl\")")
+	flag.StringVar(&cfg.Model.Markup, "model", "models/orig.txt", "model markup file")
+	flag.BoolVar(&cfg.Model.FitOutput, "fitoutput", false,
+		"resize the model's output layer to the number of classes")
+	flag.StringVar(&cfg.Model.FineTune, "finetune", "",
+		"pre-trained classifier to fine-tune (instead of -model)")
+	flag.IntVar(&cfg.Model.DropLayers, "droplayers", 2,
+		"layers to replace at the end of the fine-tuned classifier")
+	flag.IntVar(&cfg.Model.TrainLayers, "trainlayers", 0,
+		"number of layers at the end of the network to train (0 for all)")
+	flag.StringVar(&cfg.Data.ValidationSamples, "validation-samples", "",
+		"separate validation sample directory or manifest")
+	flag.StringVar(&cfg.Data.Split, "split", "filename",
+		"validation split (filename, content, stratified, or list)")
+	flag.StringVar(&cfg.Data.SplitList, "splitlist", "",
+		"validation list file (default: network file + \".split\")")
+	flag.IntVar(&workers, "workers", runtime.NumCPU(), "image loading Goroutines")
+	flag.IntVar(&prefetch, "prefetch", 2, "batches to load ahead of time")
+	flag.Int64Var(&cfg.Seed, "seed", 0, "random seed (default: based on time)")
+	flag.StringVar(&cfg.Schedule.Kind, "schedule", "const",
+		"learning rate schedule (const, step, multistep, cosine, exp, or plateau)")
+	flag.Float64Var(&cfg.Schedule.Gamma, "lrgamma", 0.1, "learning rate decay factor")
+	flag.Float64Var(&cfg.Schedule.StepEpochs, "lrstep", 30,
+		"epochs between decays for step schedule")
+	flag.Var((*floatList)(&cfg.Schedule.Milestones), "lrmilestones",
+		"comma-separated decay epochs for multistep schedule")
+	flag.Float64Var(&cfg.Schedule.TotalEpochs, "lrepochs", 90,
+		"length of cosine schedule in epochs")
+	flag.Float64Var(&cfg.Schedule.MinRate, "lrmin", 0,
+		"minimum learning rate for cosine and plateau schedules")
+	flag.Float64Var(&cfg.Schedule.WarmupEpochs, "warmup", 0,
+		"epochs of linear learning rate warmup")
+	flag.IntVar(&cfg.Schedule.Patience, "patience", 2,
+		"validation passes without improvement before plateau decay")
+	flag.IntVar(&cfg.Augmentation.MinSize, "augmin", imagenet.MinAugmentedSize,
+		"minimum size of scaled training images")
+	flag.IntVar(&cfg.Augmentation.MaxSize, "augmax", imagenet.MaxAugmentedSize,
+		"maximum size of scaled training images")
+	flag.BoolVar(&cfg.Augmentation.Mirror, "augmirror", true,
+		"randomly mirror training images")
+	flag.Float64Var(&cfg.Augmentation.ColorScale, "augcolor", 1,
+		"scale of color augmentation (0 to disable)")
+	flag.Float64Var(&cfg.Optimizer.ClipNorm, "clipnorm", 0,
+		"maximum global gradient norm (0 to disable)")
+	flag.Float64Var(&cfg.Optimizer.ClipValue, "clipvalue", 0,
+		"maximum absolute gradient component (0 to disable)")
+	flag.StringVar(&cfg.Optimizer.NonFinite, "nonfinite", "skip",
+		"response to non-finite loss or gradient (skip, lower, rollback, or abort)")
+	flag.Float64Var(&cfg.Optimizer.EMADecay, "ema", 0,
+		"decay of the weight moving average (0 to disable)")
+	flag.BoolVar(&saveRaw, "emaraw", true,
+		"with -ema, also save the raw weights (network file + \".raw\") for resuming")
+	flag.StringVar(&cfg.Loss.Kind, "loss", "ce",
+		"loss function (ce, smooth, focal, weighted, or soft)")
+	flag.Float64Var(&cfg.Loss.Smoothing, "smoothing", 0.1, "label smoothing for smooth loss")
+	flag.Float64Var(&cfg.Loss.FocalGamma, "focalgamma", 2, "focusing parameter for focal loss")
+	flag.StringVar(&cfg.Loss.ClassWeights, "classweights", "balanced",
+		"class weight file for weighted loss (or \"balanced\")")
+	flag.Float64Var(&cfg.Loss.Temperature, "temperature", 1, "temperature for soft loss")
+	flag.StringVar(&cfg.Loss.Teacher, "teacher", "", "teacher classifier for distillation")
+	flag.Float64Var(&cfg.Loss.DistillWeight, "distillweight", 0.5,
+		"weight of the distillation loss (the rest is the -loss loss)")
+	flag.Float64Var(&cfg.Loss.DistillTemperature, "distilltemp", 4,
+		"softmax temperature for distillation")
+	flag.Var((*intList)(&cfg.Resize.Sizes), "sizes",
+		"comma-separated input sizes for early epochs (progressive resizing)")
+	flag.Var((*intList)(&cfg.Resize.Epochs), "sizeepochs",
+		"comma-separated epochs at which each of -sizes ends")
+	flag.BoolVar(&cfg.Resize.ScaleBatch, "sizebatch", true,
+		"increase the batch size for smaller -sizes")
+	flag.IntVar(&cfg.Stop.MaxEpochs, "maxepochs", 0, "epochs to train for (0 for no limit)")
+	flag.IntVar(&cfg.Stop.MaxIters, "maxiters", 0, "iterations to train for (0 for no limit)")
+	flag.Float64Var(&cfg.Stop.MaxMinutes, "maxmins", 0,
+		"minutes to train for, including resumed time (0 for no limit)")
+	flag.Float64Var(&cfg.Stop.TargetTop1, "target", 0,
+		"stop once full validation reaches this top-1 accuracy (0 to disable)")
+	flag.IntVar(&cfg.Stop.Patience, "stoppatience", 0,
+		"stop after this many full validations without lower loss (0 to disable)")
+	flag.Float64Var(&cfg.Stop.MinDelta, "stopdelta", 0,
+		"decrease in validation loss which counts as an improvement")
+	flag.IntVar(&ckptIters, "ckptiters", 0, "iterations between checkpoints (0 to disable)")
+	flag.Float64Var(&ckptMinutes, "ckptmins", 30, "minutes between checkpoints (0 to disable)")
+	flag.IntVar(&ckptKeep, "ckptkeep", 3, "number of checkpoints to keep (0 for all)")
+	flag.StringVar(&resume, "resume", "", "checkpoint to resume from (or \"latest\")")
+	flag.StringVar(&cfg.Data.BadSamples, "badsamples", "skip",
+		"bad sample policy (fail, skip, or substitute)")
+	flag.IntVar(&replicas, "replicas", 1, "processes computing gradients in parallel")
+	flag.StringVar(&replicaNet, "replicanet", "unix",
+		"connection between replica processes (unix or tcp)")
+	flag.StringVar(&workerAddr, "worker", "", "coordinator address (used internally)")
+	backend.AddFlags()
 
 	flag.Parse()
 
-	if imageDir == "" || outNet == "" {
-		fmt.Fprintln(os.Stderr, "Required flags: -samples and -out")
+	isWorker := workerAddr != ""
+	if isWorker {
+		// Workers are driven by the coordinator, which is
+		// responsible for logging and handling ctrl+c.
+		log.SetOutput(ioutil.Discard)
+		signal.Ignore(os.Interrupt)
+	}
+
+	savedConfigPath := outNet + ".config.json"
+	if runDir != "" {
+		dir := RunDir(runDir)
+		if err := os.MkdirAll(runDir, 0755); err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to create run directory:", err)
+			os.Exit(1)
+		}
+		if !flagPassed("out") {
+			outNet = dir.NetPath()
+		}
+		if !flagPassed("splitlist") {
+			cfg.Data.SplitList = dir.SplitPath()
+		}
+		if metricsPath == "" {
+			metricsPath = dir.MetricsPath()
+		}
+		savedConfigPath = dir.ConfigPath()
+		if _, err := os.Stat(savedConfigPath); configPath == "" && err == nil {
+			configPath = savedConfigPath
+		}
+		if !isWorker {
+			logFile, err := os.OpenFile(dir.LogPath(), os.O_WRONLY|os.O_APPEND|os.O_CREATE,
+				0644)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Failed to open log:", err)
+				os.Exit(1)
+			}
+			defer logFile.Close()
+			log.SetOutput(io.MultiWriter(os.Stderr, logFile))
+		}
+	}
+	if configPath != "" {
+		if err := LoadConfigFile(configPath, &cfg); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	if cfg.Data.Samples == "" || outNet == "" {
+		fmt.Fprintln(os.Stderr, "Required flags: -samples (or -config) and -out (or -rundir)")
 		fmt.Fprintln(os.Stderr)
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
+	if desc, err := backend.Setup(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	} else {
+		log.Println("Using", desc)
+	}
+	imageDir := cfg.Data.Samples
+	batchSize := cfg.Optimizer.Batch
+	batchBatch := cfg.Optimizer.BatchBatch
 
-	log.Println("Loading/creating network...")
-	classifier, err := LoadOrCreateClassifier(outNet, modelFile, imageDir)
+	splitStrategy, err := imagenet.ParseSplitStrategy(cfg.Data.Split)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Failed to create network:", err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if cfg.Data.SplitList == "" {
+		cfg.Data.SplitList = outNet + ".split"
+	}
+	if metricsPath == "" {
+		metricsPath = outNet + ".metrics.jsonl"
+	}
+
+	sched := cfg.Schedule.Schedule(cfg.Optimizer.Step)
+	if err := sched.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid schedule:", err)
+		os.Exit(1)
+	}
+	badPolicy, err := imagenet.ParseBadSamplePolicy(cfg.Data.BadSamples)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	guard := &Guard{
+		ClipNorm:  cfg.Optimizer.ClipNorm,
+		ClipValue: cfg.Optimizer.ClipValue,
+		Response:  cfg.Optimizer.NonFinite,
+	}
+	if err := guard.ValidateResponse(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if runDir != "" && !isWorker && cfg.Model.Markup != RunDir(runDir).ModelPath() {
+		if err := copyModel(cfg.Model.Markup, RunDir(runDir).ModelPath()); err != nil {
+			log.Println("Failed to copy model markup:", err)
+		} else {
+			cfg.Model.Markup = RunDir(runDir).ModelPath()
+		}
+	}
+
+	ckpt := &Checkpointer{
+		OutPath:  outNet,
+		Iters:    ckptIters,
+		Interval: time.Duration(ckptMinutes * float64(time.Minute)),
+		Keep:     ckptKeep,
+	}
+
+	var classifier *imagenet.Classifier
+	var state *TrainingState
+	if resume != "" {
+		resumePath, err := ckpt.ResolveResume(resume)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to find checkpoint:", err)
+			os.Exit(1)
+		}
+		log.Println("Resuming from", resumePath, "...")
+		if err := serializer.LoadAny(resumePath, &classifier); err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to load checkpoint:", err)
+			os.Exit(1)
+		}
+		state, err = LoadTrainingState(resumePath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		} else if state == nil {
+			state = &TrainingState{Iter: checkpointIter(resumePath)}
+		}
+	} else {
+		state, err = LoadTrainingState(outNet)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		} else if state == nil {
+			state = &TrainingState{}
+		}
+	}
+	if state.Seed == 0 {
+		if cfg.Seed == 0 {
+			cfg.Seed = time.Now().UnixNano()
+		}
+		state.Seed = cfg.Seed
+	} else if cfg.Seed != 0 && cfg.Seed != state.Seed {
+		log.Println("Ignoring -seed in favor of the saved training state.")
+	}
+	cfg.Seed = state.Seed
+	if resume == "" {
+		// New networks are initialized with the global
+		// random source, so seed it to make them
+		// reproducible.
+		rand.Seed(imagenet.MixSeed(state.Seed, -3))
+		log.Println("Loading/creating network...")
+		classifier, err = LoadOrCreateClassifier(outNet, &cfg.Model, imageDir)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to create network:", err)
+			os.Exit(1)
+		}
+		if _, err := os.Stat(outNet + ".raw"); err == nil && state.EMA != nil {
+			log.Println("Loading raw weights...")
+			if err := serializer.LoadAny(outNet+".raw", &classifier); err != nil {
+				fmt.Fprintln(os.Stderr, "Failed to load raw weights:", err)
+				os.Exit(1)
+			}
+		} else if state.EMA != nil {
+			log.Println("Resuming from averaged weights.")
+		}
+	}
+	log.Println("Using random seed", state.Seed)
+	if cfg.Optimizer.Kind == "" {
+		if state.Optimizer != nil {
+			cfg.Optimizer.Kind = state.Optimizer.Name
+		} else if cfg.Optimizer.Momentum != 0 {
+			cfg.Optimizer.Kind = "momentum"
+		} else {
+			cfg.Optimizer.Kind = "adam"
+		}
+	}
+	schedule := resolveSchedule(sched, state.Schedule, configPath != "")
+	if err := schedule.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid schedule:", err)
 		os.Exit(1)
 	}
 	network := classifier.Net
 
+	if err := cfg.Augmentation.ValidateSize(classifier.InWidth, classifier.InHeight); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	resizeSchedule := cfg.Resize.Schedule(classifier.InWidth)
+	if resizeSchedule != nil {
+		if classifier.InWidth != classifier.InHeight {
+			fmt.Fprintln(os.Stderr, "Progressive resizing requires a square input size.")
+			os.Exit(1)
+		}
+		if err := resizeSchedule.Validate(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	stopper := cfg.Stop.Stopper(state.Stop)
+	if err := stopper.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if !isWorker {
+		if err := cfg.Save(savedConfigPath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	configHash := cfg.Hash()
+	if classifier.ConfigHash != "" && classifier.ConfigHash != configHash {
+		log.Println("Config differs from the one which produced the network.")
+	}
+	classifier.ConfigHash = configHash
+	log.Println("Config hash", configHash)
+
 	paramCount := 0
 	for _, p := range network.Parameters() {
 		paramCount += p.Vector.Len()
@@ -68,69 +397,673 @@ func main() {
 	log.Println("Network has", paramCount, "parameters.")
 
 	log.Println("Loading samples...")
-	samples, err := imagenet.NewSampleList(imageDir)
+	samples, err := imagenet.NewAlignedSampleList(imageDir, classifier.Classes)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Failed to read sample listing:", err)
 		os.Exit(1)
 	}
-	validation, training := anysgd.HashSplit(samples, validationSize)
+	var validation, training imagenet.SampleList
+	if cfg.Data.ValidationSamples != "" {
+		training = samples
+		validation, err = imagenet.NewAlignedSampleList(cfg.Data.ValidationSamples,
+			classifier.Classes)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to read validation samples:", err)
+			os.Exit(1)
+		}
+		if n := countClasses(validation); n < len(classifier.Classes) {
+			log.Println("Warning: validation set only covers", n, "of",
+				len(classifier.Classes), "classes.")
+		}
+	} else {
+		if splitStrategy == imagenet.ContentSplit {
+			// Avoid hashing every image again if the split has
+			// already been recorded (always the case for
+			// workers, since the coordinator records it).
+			if _, err := os.Stat(cfg.Data.SplitList); err == nil || isWorker {
+				log.Println("Using recorded split from", cfg.Data.SplitList)
+				splitStrategy = imagenet.ListSplit
+			}
+		}
+		if splitStrategy == imagenet.ContentSplit {
+			validation, training, err = samples.SplitByContent(cfg.Data.ValidationFraction,
+				badPolicy, func(s *imagenet.Sample, err error) {
+					log.Println("Bad sample:", err)
+				})
+		} else {
+			validation, training, err = samples.Split(splitStrategy,
+				cfg.Data.ValidationFraction, cfg.Data.SplitList)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to split samples:", err)
+			os.Exit(1)
+		}
+		if splitStrategy != imagenet.ListSplit && !isWorker {
+			if err := imagenet.WriteSplitList(cfg.Data.SplitList, validation); err != nil {
+				fmt.Fprintln(os.Stderr, "Failed to record split:", err)
+				os.Exit(1)
+			}
+		}
+	}
 	log.Println("Loaded", validation.Len(), "validation,", training.Len(), "training.")
 
+	rateRules, err := ParseParamRules(cfg.Optimizer.RateScales)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	noDecay, err := ParseParamSelectors(cfg.Optimizer.NoDecay)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	paramInfo := NetworkParams(network)
+	groups := NewParamGroups(paramInfo, rateRules, noDecay)
+	frozen := FrozenParams(paramInfo, len(network), cfg.Model.TrainLayers)
+	if len(frozen) > 0 {
+		log.Println("Freezing", len(frozen), "parameters.")
+		groups.DecayParams = withoutParams(groups.DecayParams, frozen)
+	}
+
+	cost, err := cfg.Loss.Cost(classifier.Classes, training)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid loss:", err)
+		os.Exit(1)
+	}
+	hardCost := cost
+	var distillCost *DistillCost
+	var teacher *imagenet.Classifier
+	if cfg.Loss.Teacher != "" {
+		if cfg.Loss.DistillTemperature <= 0 || cfg.Loss.DistillWeight < 0 ||
+			cfg.Loss.DistillWeight > 1 {
+			fmt.Fprintln(os.Stderr, "Invalid loss: distillation requires a positive",
+				"temperature and a weight between 0 and 1")
+			os.Exit(1)
+		}
+		log.Println("Loading teacher...")
+		teacher, err = LoadTeacher(cfg.Loss.Teacher, classifier)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to load teacher:", err)
+			os.Exit(1)
+		}
+		distillCost = &DistillCost{
+			Hard:        cost,
+			Weight:      cfg.Loss.DistillWeight,
+			Temperature: cfg.Loss.DistillTemperature,
+		}
+		cost = distillCost
+	}
+	var decoupledDecay float64
+	if cfg.Optimizer.DecoupledDecay {
+		decoupledDecay = cfg.Optimizer.Decay
+	} else if cfg.Optimizer.Decay != 0 {
+		cost = &anynet.L2Reg{
+			Penalty: cfg.Optimizer.Decay,
+			Params:  groups.DecayParams,
+			Wrapped: cost,
+		}
+	}
 	t := &anyff.Trainer{
-		Net: network,
-		Cost: &anynet.L2Reg{
-			Penalty: weightDecay,
-			Params:  network.Parameters(),
-			Wrapped: anynet.DotCost{},
-		},
-		Params:  anyconv.Weights(network),
+		Net:     network,
+		Cost:    cost,
+		Params:  withoutParams(anyconv.Weights(network), frozen),
+		Average: true,
+	}
+	// The evaluator always runs the network at its own
+	// input size, even while training at other sizes.
+	// It measures the loss without weight decay or
+	// distillation, so that validation losses can be
+	// compared between runs.
+	evaluator := &anyff.Trainer{
+		Net:     network,
+		Cost:    hardCost,
+		Params:  t.Params,
 		Average: true,
 	}
+	var gradienter anysgd.Gradienter = t
+	var distiller *Distiller
+	if teacher != nil {
+		distiller = &Distiller{Trainer: t, Teacher: teacher, Cost: distillCost}
+		gradienter = distiller
+	}
+	if isWorker {
+		if err := RunWorker(workerAddr, t, network.Parameters()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if replicas > 1 {
+		if teacher != nil {
+			fmt.Fprintln(os.Stderr, "Distillation is not supported with -replicas.")
+			os.Exit(1)
+		} else if resizeSchedule != nil {
+			fmt.Fprintln(os.Stderr, "Progressive resizing is not supported with -replicas.")
+			os.Exit(1)
+		}
+		// Split the threads between the processes, so that
+		// the workers do not compete for every core.
+		// The main process keeps all of its threads, since
+		// it also loads the images for every shard.
+		workerThreads := backend.Threads() / replicas
+		if workerThreads < 1 {
+			workerThreads = 1
+		}
+		log.Println("Starting", replicas-1, "worker processes with", workerThreads,
+			"threads each...")
+		coord, err := StartReplicas(replicas, workerThreads, replicaNet, t,
+			network.Parameters())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer coord.Close()
+		gradienter = coord
+	}
+	guard.LossFunc = func() float64 {
+		return numToFloat(t.LastCost)
+	}
 
-	vBatches := make(chan anysgd.Batch, 1)
-	go func() {
-		for {
-			anysgd.Shuffle(validation)
-			valid := validation.Slice(0, batchSize)
-			batch, err := t.Fetch(valid)
+	var ema *EMA
+	if cfg.Optimizer.EMADecay > 0 {
+		ema = NewEMA(network.Parameters(), cfg.Optimizer.EMADecay)
+		if state.EMA != nil {
+			if err := ema.LoadState(state.EMA); err != nil {
+				fmt.Fprintln(os.Stderr, "Failed to restore moving average:", err)
+				os.Exit(1)
+			}
+		}
+	}
+	// withAverage runs f with the averaged weights, if
+	// there are any.
+	withAverage := func(f func()) {
+		if ema != nil {
+			ema.Swap()
+			defer ema.Swap()
+		}
+		f()
+	}
+
+	fetcher := newFetcher(badPolicy, training, workers, &cfg.Augmentation,
+		classifier.InWidth, classifier.InHeight)
+	vFetcher := newFetcher(badPolicy, validation, workers, &cfg.Augmentation,
+		classifier.InWidth, classifier.InHeight)
+
+	optimizer, err := NewOptimizer(cfg.Optimizer.Kind, cfg.Optimizer.Momentum,
+		cfg.Optimizer.Trust)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	log.Println("Using", cfg.Optimizer.Kind, "optimizer.")
+	if state.Optimizer != nil {
+		if err := optimizer.LoadState(t.Params, state.Optimizer); err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to restore optimizer:", err)
+			os.Exit(1)
+		}
+	}
+
+	// validCount is the index of the next validation batch.
+	// Batches which fail to load are skipped, so each batch
+	// is sent along with its index, allowing a resumed run
+	// to continue with the same batches.
+	validCount := state.ValidationBatches
+	type validBatch struct {
+		Batch anysgd.Batch
+		Index int
+	}
+	vBatches := make(chan validBatch, 1)
+	go func(start int, validSeed int64) {
+		validBatchSize := batchSize
+		if validBatchSize > len(validation) {
+			validBatchSize = len(validation)
+		}
+		for i := start; ; i++ {
+			gen := rand.New(rand.NewSource(imagenet.MixSeed(validSeed, int64(i))))
+			var valid imagenet.SampleList
+			for _, j := range gen.Perm(len(validation))[:validBatchSize] {
+				valid = append(valid, validation[j])
+			}
+			batch, err := vFetcher.FetchRand(valid, gen)
 			if err != nil {
-				essentials.Die(err)
+				if badPolicy == imagenet.FailOnBadSamples {
+					essentials.Die(err)
+				}
+				continue
 			}
-			vBatches <- batch
+			vBatches <- validBatch{Batch: batch, Index: i}
 		}
-	}()
+	}(validCount, imagenet.MixSeed(state.Seed, -1))
+
+	metrics, err := imagenet.NewMetricsWriter(metricsPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer metrics.Close()
+	startTime := time.Now().Add(-time.Duration(state.WallTime * float64(time.Second)))
+	wallTime := func() float64 {
+		return time.Since(startTime).Seconds()
+	}
+	writeMetrics := func(r *imagenet.MetricsRecord) {
+		if err := metrics.Write(r); err != nil {
+			log.Println(err)
+		}
+	}
+
+	evalSamples := validation
+	if validSubset > 0 && validSubset < len(validation) {
+		gen := rand.New(rand.NewSource(imagenet.MixSeed(state.Seed, -2)))
+		evalSamples = nil
+		for _, i := range gen.Perm(len(validation))[:validSubset] {
+			evalSamples = append(evalSamples, validation[i])
+		}
+	}
+	evalLoader := &imagenet.Loader{
+		Fetcher: &imagenet.Fetcher{
+			Policy:      badPolicy,
+			Pool:        validation,
+			Workers:     workers,
+			Testing:     true,
+			ImageWidth:  classifier.InWidth,
+			ImageHeight: classifier.InHeight,
+		},
+		Prefetch: prefetch,
+	}
+	var s *SGD
+	iterNum := state.Iter
+	bestAccuracy := state.BestAccuracy
+	runValidation := func() {
+		log.Println("Running validation on", len(evalSamples), "samples...")
+		var res *ValidationResult
+		var err error
+		withAverage(func() {
+			res, err = validate(evaluator, evalLoader, evalSamples, batchSize)
+		})
+		if err != nil {
+			log.Println("Validation failed:", err)
+			return
+		}
+		log.Printf("iter %d: validation loss=%f top1=%f top5=%f", iterNum, res.Loss,
+			res.Top1, res.Top5)
+		writeMetrics(&imagenet.MetricsRecord{
+			Kind:           imagenet.ValidationMetrics,
+			Iter:           iterNum,
+			Epoch:          s.epochProgress(),
+			WallTime:       wallTime(),
+			ValidationLoss: res.Loss,
+			Top1:           res.Top1,
+			Top5:           res.Top5,
+		})
+		if schedule.Observe(res.Top1) {
+			log.Println("Validation accuracy plateaued; decaying learning rate.")
+		}
+		stopper.Observe(res.Loss)
+		if res.Top1 > bestAccuracy {
+			bestAccuracy = res.Top1
+			log.Println("Saving best network...")
+			withAverage(func() {
+				err = SaveClassifier(outNet+".best", classifier)
+			})
+			if err != nil {
+				log.Println("Failed to save best network:", err)
+			}
+		}
+	}
+
+	var makeState func() *TrainingState
+	lastStatusTime := time.Now()
+	s = &SGD{
+		Loader: &imagenet.Loader{
+			Fetcher:  fetcher,
+			Prefetch: prefetch,
+		},
+		Gradienter:  gradienter,
+		Transformer: optimizer,
+		Samples:     training,
+		Rater:       schedule,
+		Groups:      groups,
+
+		DecoupledDecay: decoupledDecay,
+		Guard:          guard,
+		RateScale:      state.RateScale,
 
-	var iterNum int
-	s := &anysgd.SGD{
-		Fetcher:     t,
-		Gradienter:  t,
-		Transformer: &anysgd.Adam{},
-		Samples:     samples,
-		Rater:       anysgd.ConstRater(stepSize),
 		StatusFunc: func(b anysgd.Batch) {
+			now := time.Now()
+			numImages := batchBatch * b.(*anyff.Batch).Num
+			writeMetrics(&imagenet.MetricsRecord{
+				Kind:         imagenet.TrainMetrics,
+				Iter:         iterNum,
+				Epoch:        s.epochProgress(),
+				WallTime:     wallTime(),
+				LearningRate: s.rate(),
+				TrainLoss:    numToFloat(t.LastCost),
+				ImagesPerSec: float64(numImages) / now.Sub(lastStatusTime).Seconds(),
+			})
+			lastStatusTime = now
+
 			if iterNum%logInterval != 1 {
 				log.Printf("iter %d: cost=%v", iterNum, t.LastCost)
 			} else {
 				batch := <-vBatches
-				vCost := anyvec.Sum(t.TotalCost(batch.(*anyff.Batch)).Output())
-				log.Printf("iter %d: cost=%v validation=%v", iterNum, vCost, t.LastCost)
+				validCount = batch.Index + 1
+				vCost, _, _ := evaluateBatch(evaluator, batch.Batch.(*anyff.Batch))
+				log.Printf("iter %d: cost=%v validation=%v", iterNum, t.LastCost, vCost)
 			}
 			iterNum++
 		},
-		BatchSize: batchSize,
+		EpochFunc: func() {
+			log.Println("Finished epoch", s.Epoch)
+			if validInterval == 0 {
+				runValidation()
+			}
+		},
+		StepFunc: func() error {
+			if ema != nil {
+				ema.Update()
+			}
+			if validInterval > 0 && iterNum%validInterval == 0 {
+				runValidation()
+			}
+			if ckpt.Due(iterNum) {
+				log.Println("Saving checkpoint...")
+				if err := ckpt.Save(iterNum, classifier, makeState()); err != nil {
+					log.Println(err)
+				}
+			}
+			return nil
+		},
+		DoneFunc: func() bool {
+			reason := stopper.Reason(s.Epoch, iterNum, wallTime(), bestAccuracy)
+			if reason == "" {
+				return false
+			}
+			log.Println("Finished training:", reason)
+			return true
+		},
+		BatchSize:  batchSize,
+		BatchBatch: batchBatch,
+		Resize:     resizeSchedule,
+
+		Seed:         state.Seed,
+		NumProcessed: state.NumProcessed,
+		Epoch:        state.Epoch,
+		Perm:         state.Perm,
+		Position:     state.Position,
 	}
-	if momentum != 0 {
-		s.Transformer = &anysgd.Momentum{Momentum: momentum}
+	fetchers := map[int]*imagenet.Fetcher{classifier.InWidth: fetcher}
+	resized := map[int]*imagenet.Classifier{}
+	resizedTeachers := map[int]*imagenet.Classifier{}
+	lastSize := -1
+	s.ResizeFunc = func(size, batchSize int) error {
+		if size == lastSize {
+			return nil
+		}
+		lastSize = size
+		log.Println("Training at size", size, "with batch size", batchSize)
+		if _, ok := resized[size]; !ok {
+			cl, err := classifier.Resize(size, size)
+			if err != nil {
+				return err
+			}
+			resized[size] = cl
+			if teacher != nil {
+				resizedTeacher, err := teacher.Resize(size, size)
+				if err != nil {
+					return essentials.AddCtx("resize teacher", err)
+				}
+				resizedTeachers[size] = resizedTeacher
+			}
+		}
+		if _, ok := fetchers[size]; !ok {
+			fetchers[size] = newFetcher(badPolicy, training, workers, &cfg.Augmentation,
+				size, size)
+		}
+		t.Net = resized[size].Net
+		if distiller != nil {
+			distiller.Teacher = resizedTeachers[size]
+		}
+		s.Loader = &imagenet.Loader{Fetcher: fetchers[size], Prefetch: prefetch}
+		return nil
+	}
+	if s.Perm != nil && len(s.Perm) != len(training) {
+		log.Println("Training samples have changed; starting a new epoch.")
+		s.Perm = nil
+	}
+	makeState = func() *TrainingState {
+		var emaState *EMAState
+		if ema != nil {
+			emaState = ema.SaveState()
+		}
+		return &TrainingState{
+			Seed:              s.Seed,
+			Iter:              iterNum,
+			NumProcessed:      s.NumProcessed,
+			Epoch:             s.Epoch,
+			Perm:              s.Perm,
+			Position:          s.Position,
+			ValidationBatches: validCount,
+			BestAccuracy:      bestAccuracy,
+			WallTime:          wallTime(),
+			RateScale:         s.RateScale,
+			Schedule:          schedule,
+			Optimizer:         optimizer.SaveState(t.Params),
+			EMA:               emaState,
+			Stop:              stopper.State,
+		}
+	}
+
+	rollback := func() error {
+		paths, err := ckpt.Checkpoints()
+		if err != nil {
+			return err
+		} else if len(paths) == 0 {
+			return errors.New("no checkpoint to roll back to")
+		}
+		path := paths[len(paths)-1]
+		var saved *imagenet.Classifier
+		if err := serializer.LoadAny(path, &saved); err != nil {
+			return err
+		}
+		savedState, err := LoadTrainingState(path)
+		if err != nil {
+			return err
+		} else if savedState == nil {
+			return errors.New("missing training state for " + path)
+		}
+		if err := copyParams(network, saved.Net); err != nil {
+			return err
+		}
+		if savedState.Optimizer != nil {
+			if err := optimizer.LoadState(t.Params, savedState.Optimizer); err != nil {
+				return err
+			}
+		}
+		if ema != nil && savedState.EMA != nil {
+			if err := ema.LoadState(savedState.EMA); err != nil {
+				return err
+			}
+		}
+		if savedState.Schedule != nil {
+			schedule.PlateauScale = savedState.Schedule.PlateauScale
+			schedule.PlateauBest = savedState.Schedule.PlateauBest
+			schedule.PlateauBad = savedState.Schedule.PlateauBad
+		}
+		stopper.State = savedState.Stop
+		iterNum = savedState.Iter
+		s.NumProcessed = savedState.NumProcessed
+		s.Epoch = savedState.Epoch
+		s.Perm = savedState.Perm
+		s.Position = savedState.Position
+		s.RateScale = s.rateScale() / 2
+		log.Println("Rolled back to", path, "and scaled learning rate by", s.RateScale)
+		return nil
 	}
 
 	log.Println("Press ctrl+c once to stop...")
-	err = s.RunAvg(batchBatch, rip.NewRIP().Chan())
+	stop := rip.NewRIP().Chan()
+	for {
+		err = s.Run(stop)
+		if err != ErrRollback {
+			break
+		}
+		if err = rollback(); err != nil {
+			err = essentials.AddCtx("roll back", err)
+			break
+		}
+	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Training error:", err)
 	}
+	numBad := len(vFetcher.BadSamples())
+	for _, f := range fetchers {
+		numBad += len(f.BadSamples())
+	}
+	if numBad > 0 {
+		switch badPolicy {
+		case imagenet.SkipBadSamples:
+			log.Println("Skipped", numBad, "bad samples.")
+		case imagenet.SubstituteBadSamples:
+			log.Println("Substituted", numBad, "bad samples.")
+		default:
+			log.Println("Found", numBad, "bad samples.")
+		}
+	}
 
-	if err := serializer.SaveAny(outNet, classifier); err != nil {
+	if ema != nil && saveRaw {
+		if err := SaveClassifier(outNet+".raw", classifier); err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to save raw network:", err)
+			os.Exit(1)
+		}
+	}
+	withAverage(func() {
+		err = SaveClassifier(outNet, classifier)
+	})
+	if err != nil {
 		fmt.Fprintln(os.Stderr, "Failed to save network:", err)
 		os.Exit(1)
 	}
+	if err := makeState().Save(outNet); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to save training state:", err)
+		os.Exit(1)
+	}
+}
+
+// checkpointIter extracts the iteration number from the
+// name of a checkpoint file.
+func checkpointIter(path string) int {
+	idx := strings.LastIndex(path, ".ckpt-")
+	if idx < 0 {
+		return 0
+	}
+	iter, _ := strconv.Atoi(path[idx+len(".ckpt-"):])
+	return iter
+}
+
+// resolveSchedule decides which learning rate schedule
+// to use, given the schedule from the flags and the saved
+// schedule (which may be nil).
+//
+// If a config file was loaded, it fully specifies the
+// schedule, so the flags' schedule is used.
+// Otherwise, the saved schedule is used, with any
+// schedule flags that were passed explicitly applied on
+// top of it.
+// The plateau state is kept unless the kind of schedule
+// changes.
+func resolveSchedule(flags, saved *Schedule, fromConfig bool) *Schedule {
+	if saved == nil {
+		return flags
+	}
+	var res Schedule
+	if fromConfig {
+		res = *flags
+		res.PlateauScale = saved.PlateauScale
+		res.PlateauBest = saved.PlateauBest
+		res.PlateauBad = saved.PlateauBad
+	} else {
+		res = *saved
+		overrides := map[string]func(){
+			"step":         func() { res.Base = flags.Base },
+			"schedule":     func() { res.Kind = flags.Kind },
+			"lrgamma":      func() { res.Gamma = flags.Gamma },
+			"lrstep":       func() { res.StepEpochs = flags.StepEpochs },
+			"lrmilestones": func() { res.Milestones = flags.Milestones },
+			"lrepochs":     func() { res.TotalEpochs = flags.TotalEpochs },
+			"lrmin":        func() { res.MinRate = flags.MinRate },
+			"warmup":       func() { res.WarmupEpochs = flags.WarmupEpochs },
+			"patience":     func() { res.Patience = flags.Patience },
+		}
+		for name, apply := range overrides {
+			if flagPassed(name) {
+				apply()
+			}
+		}
+	}
+	if res.Kind != saved.Kind {
+		res.PlateauScale = 0
+		res.PlateauBest = 0
+		res.PlateauBad = 0
+	}
+	return &res
+}
+
+// flagPassed checks if a flag was set on the command line.
+func flagPassed(name string) bool {
+	var res bool
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			res = true
+		}
+	})
+	return res
+}
+
+// copyModel copies a model markup file.
+func copyModel(src, dst string) error {
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(dst, data)
+}
+
+// copyParams copies the parameters of one network into
+// another network with the same architecture.
+func copyParams(dst, src anynet.Net) error {
+	dstParams := dst.Parameters()
+	srcParams := src.Parameters()
+	if len(dstParams) != len(srcParams) {
+		return errors.New("copy parameters: parameter count mismatch")
+	}
+	for i, p := range dstParams {
+		if p.Vector.Len() != srcParams[i].Vector.Len() {
+			return errors.New("copy parameters: parameter size mismatch")
+		}
+		p.Vector.Set(srcParams[i].Vector)
+	}
+	return nil
+}
+
+func countClasses(samples imagenet.SampleList) int {
+	classes := map[int]bool{}
+	for _, sample := range samples {
+		classes[sample.Class] = true
+	}
+	return len(classes)
+}
+
+func newFetcher(policy imagenet.BadSamplePolicy, pool imagenet.SampleList,
+	workers int, aug *imagenet.Augmentation, width, height int) *imagenet.Fetcher {
+	return &imagenet.Fetcher{
+		Policy:       policy,
+		Pool:         pool,
+		Workers:      workers,
+		Augmentation: aug,
+		ImageWidth:   width,
+		ImageHeight:  height,
+		LogFunc: func(s *imagenet.Sample, err error) {
+			log.Println("Bad sample:", err)
+		},
+	}
 }
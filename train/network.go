@@ -1,17 +1,21 @@
 package main
 
 import (
+	"fmt"
 	"io/ioutil"
+	"log"
 	"sort"
 
+	"github.com/unixpickle/anydiff"
 	"github.com/unixpickle/anynet"
-	"github.com/unixpickle/anynet/anyconv"
 	"github.com/unixpickle/anyvec/anyvec32"
+	"github.com/unixpickle/essentials"
 	"github.com/unixpickle/imagenet"
 	"github.com/unixpickle/serializer"
 )
 
-func LoadOrCreateClassifier(path, modelPath, samplePath string) (*imagenet.Classifier, error) {
+func LoadOrCreateClassifier(path string, model *ModelConfig,
+	samplePath string) (*imagenet.Classifier, error) {
 	var net anynet.Net
 	if err := serializer.LoadAny(path, &net); err == nil {
 		return turnIntoClassifier(net, samplePath)
@@ -22,18 +26,103 @@ func LoadOrCreateClassifier(path, modelPath, samplePath string) (*imagenet.Class
 		return cl, nil
 	}
 
-	modelData, err := ioutil.ReadFile(modelPath)
+	if model.FineTune != "" {
+		return FineTuneClassifier(model.FineTune, model.DropLayers, samplePath)
+	}
+
+	modelData, err := ioutil.ReadFile(model.Markup)
+	if err != nil {
+		return nil, err
+	}
+	net, err = imagenet.NetFromMarkup(anyvec32.CurrentCreator(), string(modelData))
 	if err != nil {
 		return nil, err
 	}
-	res, err := anyconv.FromMarkup(anyvec32.CurrentCreator(), string(modelData))
+	classes, err := sampleClasses(samplePath)
 	if err != nil {
 		return nil, err
 	}
-	return turnIntoClassifier(res.(anynet.Net), samplePath)
+	if err := fitOutputLayer(net, len(classes), model.FitOutput); err != nil {
+		return nil, err
+	}
+	return turnIntoClassifier(net, samplePath)
+}
+
+// fitOutputLayer checks that the last fully-connected
+// layer of a new network has one output per class.
+//
+// If replace is set, a mismatched layer is replaced, so
+// that models written for all of ImageNet can be trained
+// on a subset of the classes.
+func fitOutputLayer(net anynet.Net, numClasses int, replace bool) error {
+	for i := len(net) - 1; i >= 0; i-- {
+		fc, ok := net[i].(*anynet.FC)
+		if !ok {
+			continue
+		} else if fc.OutCount == numClasses {
+			return nil
+		} else if !replace {
+			return fmt.Errorf("model has %d outputs but there are %d classes "+
+				"(pass -fitoutput to resize the output layer)", fc.OutCount, numClasses)
+		}
+		log.Printf("Resizing output layer from %d to %d classes", fc.OutCount, numClasses)
+		net[i] = anynet.NewFC(anyvec32.CurrentCreator(), fc.InCount, numClasses)
+		return nil
+	}
+	return nil
+}
+
+// FineTuneClassifier loads a pre-trained classifier,
+// removes its last dropLayers top-level layers, and
+// appends a new fully-connected layer and softmax for the
+// classes in the sample directory.
+func FineTuneClassifier(path string, dropLayers int,
+	samplePath string) (*imagenet.Classifier, error) {
+	var cl *imagenet.Classifier
+	if err := serializer.LoadAny(path, &cl); err != nil {
+		return nil, essentials.AddCtx("fine-tune", err)
+	}
+	if dropLayers < 0 || dropLayers >= len(cl.Net) {
+		return nil, fmt.Errorf("fine-tune: cannot drop %d of %d layers", dropLayers,
+			len(cl.Net))
+	}
+	classes, err := sampleClasses(samplePath)
+	if err != nil {
+		return nil, essentials.AddCtx("fine-tune", err)
+	}
+	net := append(anynet.Net{}, cl.Net[:len(cl.Net)-dropLayers]...)
+
+	c := anyvec32.CurrentCreator()
+	in := anydiff.NewConst(c.MakeVector(cl.InWidth * cl.InHeight * 3))
+	featureCount := net.Apply(in, 1).Output().Len()
+	net = append(net, anynet.NewFC(c, featureCount, len(classes)), anynet.LogSoftmax)
+
+	return &imagenet.Classifier{
+		InWidth:  cl.InWidth,
+		InHeight: cl.InHeight,
+		Net:      net,
+		Classes:  classes,
+	}, nil
 }
 
 func turnIntoClassifier(net anynet.Net, samplePath string) (*imagenet.Classifier, error) {
+	classes, err := sampleClasses(samplePath)
+	if err != nil {
+		return nil, err
+	}
+	width, height, ok := imagenet.InputSize(net)
+	if !ok {
+		width, height = imagenet.InputImageSize, imagenet.InputImageSize
+	}
+	return &imagenet.Classifier{
+		InWidth:  width,
+		InHeight: height,
+		Net:      net,
+		Classes:  classes,
+	}, nil
+}
+
+func sampleClasses(samplePath string) ([]string, error) {
 	listing, err := ioutil.ReadDir(samplePath)
 	if err != nil {
 		return nil, err
@@ -45,10 +134,5 @@ func turnIntoClassifier(net anynet.Net, samplePath string) (*imagenet.Classifier
 		}
 	}
 	sort.Strings(dirNames)
-	return &imagenet.Classifier{
-		InWidth:  imagenet.InputImageSize,
-		InHeight: imagenet.InputImageSize,
-		Net:      net,
-		Classes:  dirNames,
-	}, nil
+	return dirNames, nil
 }
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"errors"
+	"math"
+
+	"github.com/unixpickle/anydiff"
+	"github.com/unixpickle/anynet/anysgd"
+	"github.com/unixpickle/anyvec"
+	"github.com/unixpickle/anyvec/anyvec32"
+)
+
+// An Optimizer is an anysgd.Transformer whose internal
+// state can be saved and restored, so that training can
+// be resumed without losing momentum.
+type Optimizer interface {
+	anysgd.Transformer
+
+	// SaveState exports the state for the given parameters.
+	SaveState(params []*anydiff.Var) *OptimizerState
+
+	// LoadState imports a state saved by SaveState.
+	LoadState(params []*anydiff.Var, state *OptimizerState) error
+}
+
+// OptimizerState is the serializable state of an
+// Optimizer.
+type OptimizerState struct {
+	Name string
+	Step int
+
+	// Buffers maps buffer names (e.g. "momentum") to one
+	// vector per parameter, ordered like the parameters.
+	Buffers map[string][][]float32
+}
+
+// Momentum implements SGD with classical or Nesterov
+// momentum.
+type Momentum struct {
+	Momentum float64
+	Nesterov bool
+
+	velocity map[*anydiff.Var]anyvec.Vector
+}
+
+// Transform applies momentum to the gradient.
+func (m *Momentum) Transform(g anydiff.Grad) anydiff.Grad {
+	if m.velocity == nil {
+		m.velocity = map[*anydiff.Var]anyvec.Vector{}
+	}
+	for variable, grad := range g {
+		v, ok := m.velocity[variable]
+		if !ok {
+			v = grad.Copy()
+			m.velocity[variable] = v
+		} else {
+			v.Scale(v.Creator().MakeNumeric(m.Momentum))
+			v.Add(grad)
+		}
+		if m.Nesterov {
+			lookahead := v.Copy()
+			lookahead.Scale(v.Creator().MakeNumeric(m.Momentum))
+			grad.Add(lookahead)
+		} else {
+			grad.Set(v)
+		}
+	}
+	return g
+}
+
+// SaveState saves the velocity.
+func (m *Momentum) SaveState(params []*anydiff.Var) *OptimizerState {
+	return m.saveState(m.name(), params)
+}
+
+// LoadState loads the velocity.
+func (m *Momentum) LoadState(params []*anydiff.Var, state *OptimizerState) error {
+	return m.loadState(m.name(), params, state)
+}
+
+func (m *Momentum) name() string {
+	if m.Nesterov {
+		return "nesterov"
+	}
+	return "momentum"
+}
+
+func (m *Momentum) saveState(name string, params []*anydiff.Var) *OptimizerState {
+	return &OptimizerState{
+		Name:    name,
+		Buffers: map[string][][]float32{"velocity": saveBuffer(params, m.velocity)},
+	}
+}
+
+func (m *Momentum) loadState(name string, params []*anydiff.Var,
+	state *OptimizerState) error {
+	if state.Name != name {
+		return errors.New("load optimizer: expected " + name + " state but got " +
+			state.Name)
+	}
+	var err error
+	m.velocity, err = loadBuffer(params, state.Buffers["velocity"])
+	return err
+}
+
+// Adam implements the Adam optimizer.
+//
+// It uses the same default hyper-parameters as
+// anysgd.Adam.
+type Adam struct {
+	DecayRate1 float64
+	DecayRate2 float64
+	Damping    float64
+
+	step   int
+	first  map[*anydiff.Var]anyvec.Vector
+	second map[*anydiff.Var]anyvec.Vector
+}
+
+// Transform applies Adam to the gradient.
+func (a *Adam) Transform(g anydiff.Grad) anydiff.Grad {
+	if a.first == nil {
+		a.first = map[*anydiff.Var]anyvec.Vector{}
+		a.second = map[*anydiff.Var]anyvec.Vector{}
+	}
+	a.step++
+	d1, d2, damping := a.hyperParams()
+	scale1 := 1 / (1 - math.Pow(d1, float64(a.step)))
+	scale2 := 1 / (1 - math.Pow(d2, float64(a.step)))
+	for variable, grad := range g {
+		c := grad.Creator()
+		first, ok := a.first[variable]
+		if !ok {
+			first = c.MakeVector(grad.Len())
+			a.first[variable] = first
+			a.second[variable] = c.MakeVector(grad.Len())
+		}
+		second := a.second[variable]
+
+		first.Scale(c.MakeNumeric(d1))
+		scaled := grad.Copy()
+		scaled.Scale(c.MakeNumeric(1 - d1))
+		first.Add(scaled)
+
+		second.Scale(c.MakeNumeric(d2))
+		sq := grad.Copy()
+		sq.Mul(grad)
+		sq.Scale(c.MakeNumeric(1 - d2))
+		second.Add(sq)
+
+		denom := second.Copy()
+		denom.Scale(c.MakeNumeric(scale2))
+		anyvec.Pow(denom, c.MakeNumeric(0.5))
+		denom.AddScalar(c.MakeNumeric(damping))
+		grad.Set(first)
+		grad.Scale(c.MakeNumeric(scale1))
+		grad.Div(denom)
+	}
+	return g
+}
+
+// SaveState saves the moment estimates.
+func (a *Adam) SaveState(params []*anydiff.Var) *OptimizerState {
+	return a.saveState("adam", params)
+}
+
+// LoadState loads the moment estimates.
+func (a *Adam) LoadState(params []*anydiff.Var, state *OptimizerState) error {
+	return a.loadState("adam", params, state)
+}
+
+func (a *Adam) saveState(name string, params []*anydiff.Var) *OptimizerState {
+	return &OptimizerState{
+		Name: name,
+		Step: a.step,
+		Buffers: map[string][][]float32{
+			"first":  saveBuffer(params, a.first),
+			"second": saveBuffer(params, a.second),
+		},
+	}
+}
+
+func (a *Adam) loadState(name string, params []*anydiff.Var,
+	state *OptimizerState) error {
+	if state.Name != name {
+		return errors.New("load optimizer: expected " + name + " state but got " +
+			state.Name)
+	}
+	first, err := loadBuffer(params, state.Buffers["first"])
+	if err != nil {
+		return err
+	}
+	second, err := loadBuffer(params, state.Buffers["second"])
+	if err != nil {
+		return err
+	}
+	a.step, a.first, a.second = state.Step, first, second
+	return nil
+}
+
+func (a *Adam) hyperParams() (d1, d2, damping float64) {
+	d1, d2, damping = a.DecayRate1, a.DecayRate2, a.Damping
+	if d1 == 0 {
+		d1 = 0.9
+	}
+	if d2 == 0 {
+		d2 = 0.999
+	}
+	if damping == 0 {
+		damping = 1e-8
+	}
+	return
+}
+
+// RMSProp implements the RMSProp optimizer, which divides
+// the gradient by a running average of its magnitude.
+type RMSProp struct {
+	// DecayRate is the decay of the running average.
+	// It defaults to 0.9.
+	DecayRate float64
+
+	// Damping is added to the denominator for numerical
+	// stability.
+	// It defaults to 1e-8.
+	Damping float64
+
+	meanSquare map[*anydiff.Var]anyvec.Vector
+}
+
+// Transform applies RMSProp to the gradient.
+func (r *RMSProp) Transform(g anydiff.Grad) anydiff.Grad {
+	if r.meanSquare == nil {
+		r.meanSquare = map[*anydiff.Var]anyvec.Vector{}
+	}
+	decay, damping := r.DecayRate, r.Damping
+	if decay == 0 {
+		decay = 0.9
+	}
+	if damping == 0 {
+		damping = 1e-8
+	}
+	for variable, grad := range g {
+		c := grad.Creator()
+		meanSquare, ok := r.meanSquare[variable]
+		if !ok {
+			meanSquare = c.MakeVector(grad.Len())
+			r.meanSquare[variable] = meanSquare
+		}
+		meanSquare.Scale(c.MakeNumeric(decay))
+		sq := grad.Copy()
+		sq.Mul(grad)
+		sq.Scale(c.MakeNumeric(1 - decay))
+		meanSquare.Add(sq)
+
+		denom := meanSquare.Copy()
+		anyvec.Pow(denom, c.MakeNumeric(0.5))
+		denom.AddScalar(c.MakeNumeric(damping))
+		grad.Div(denom)
+	}
+	return g
+}
+
+// SaveState saves the running averages.
+func (r *RMSProp) SaveState(params []*anydiff.Var) *OptimizerState {
+	return &OptimizerState{
+		Name:    "rmsprop",
+		Buffers: map[string][][]float32{"meanSquare": saveBuffer(params, r.meanSquare)},
+	}
+}
+
+// LoadState loads the running averages.
+func (r *RMSProp) LoadState(params []*anydiff.Var, state *OptimizerState) error {
+	if state.Name != "rmsprop" {
+		return errors.New("load optimizer: expected rmsprop state but got " + state.Name)
+	}
+	var err error
+	r.meanSquare, err = loadBuffer(params, state.Buffers["meanSquare"])
+	return err
+}
+
+// LARS implements layer-wise adaptive rate scaling
+// (https://arxiv.org/abs/1708.03888).
+//
+// Each parameter's gradient is scaled by Trust times the
+// ratio of the parameter's norm to the gradient's norm,
+// and then momentum is applied.
+type LARS struct {
+	Momentum
+
+	// Trust is the trust coefficient.
+	Trust float64
+}
+
+// Transform applies LARS to the gradient.
+func (l *LARS) Transform(g anydiff.Grad) anydiff.Grad {
+	for variable, grad := range g {
+		scaleByTrustRatio(variable, grad, l.Trust)
+	}
+	return l.Momentum.Transform(g)
+}
+
+// SaveState saves the velocity.
+func (l *LARS) SaveState(params []*anydiff.Var) *OptimizerState {
+	return l.Momentum.saveState("lars", params)
+}
+
+// LoadState loads the velocity.
+func (l *LARS) LoadState(params []*anydiff.Var, state *OptimizerState) error {
+	return l.Momentum.loadState("lars", params, state)
+}
+
+// LAMB implements layer-wise adaptive moments
+// (https://arxiv.org/abs/1904.00962).
+//
+// Each parameter's Adam step is scaled by the ratio of the
+// parameter's norm to the step's norm.
+type LAMB struct {
+	Adam
+}
+
+// Transform applies LAMB to the gradient.
+func (l *LAMB) Transform(g anydiff.Grad) anydiff.Grad {
+	g = l.Adam.Transform(g)
+	for variable, step := range g {
+		scaleByTrustRatio(variable, step, 1)
+	}
+	return g
+}
+
+// SaveState saves the moment estimates.
+func (l *LAMB) SaveState(params []*anydiff.Var) *OptimizerState {
+	return l.Adam.saveState("lamb", params)
+}
+
+// LoadState loads the moment estimates.
+func (l *LAMB) LoadState(params []*anydiff.Var, state *OptimizerState) error {
+	return l.Adam.loadState("lamb", params, state)
+}
+
+// NewOptimizer creates an optimizer by name.
+func NewOptimizer(name string, momentum, trust float64) (Optimizer, error) {
+	switch name {
+	case "adam":
+		return &Adam{}, nil
+	case "momentum":
+		return &Momentum{Momentum: momentum}, nil
+	case "nesterov":
+		return &Momentum{Momentum: momentum, Nesterov: true}, nil
+	case "rmsprop":
+		return &RMSProp{}, nil
+	case "lars":
+		return &LARS{Momentum: Momentum{Momentum: momentum}, Trust: trust}, nil
+	case "lamb":
+		return &LAMB{}, nil
+	default:
+		return nil, errors.New("unknown optimizer: " + name)
+	}
+}
+
+// scaleByTrustRatio scales a parameter's update by coeff
+// times the ratio of the parameter norm to the update
+// norm.
+// If either norm is zero, the update is left unchanged.
+func scaleByTrustRatio(variable *anydiff.Var, update anyvec.Vector, coeff float64) {
+	paramNorm := math.Sqrt(numToFloat(variable.Vector.Dot(variable.Vector)))
+	updateNorm := math.Sqrt(numToFloat(update.Dot(update)))
+	if paramNorm == 0 || updateNorm == 0 {
+		return
+	}
+	update.Scale(update.Creator().MakeNumeric(coeff * paramNorm / updateNorm))
+}
+
+func saveBuffer(params []*anydiff.Var, buf map[*anydiff.Var]anyvec.Vector) [][]float32 {
+	if buf == nil {
+		return nil
+	}
+	res := make([][]float32, len(params))
+	for i, p := range params {
+		if vec, ok := buf[p]; ok {
+			res[i] = vec.Data().([]float32)
+		}
+	}
+	return res
+}
+
+func loadBuffer(params []*anydiff.Var, data [][]float32) (map[*anydiff.Var]anyvec.Vector,
+	error) {
+	if data == nil {
+		return nil, nil
+	}
+	if len(data) != len(params) {
+		return nil, errors.New("load optimizer: parameter count mismatch")
+	}
+	res := map[*anydiff.Var]anyvec.Vector{}
+	for i, p := range params {
+		if len(data[i]) == 0 {
+			continue
+		}
+		if len(data[i]) != p.Vector.Len() {
+			return nil, errors.New("load optimizer: parameter size mismatch")
+		}
+		res[p] = anyvec32.MakeVectorData(data[i])
+	}
+	return res, nil
+}
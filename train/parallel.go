@@ -0,0 +1,341 @@
+package main
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/unixpickle/anydiff"
+	"github.com/unixpickle/anynet/anyff"
+	"github.com/unixpickle/anynet/anysgd"
+	"github.com/unixpickle/essentials"
+)
+
+// A Coordinator is an anysgd.Gradienter which splits each
+// batch into shards and computes their gradients in
+// parallel, using worker processes on the same machine.
+//
+// Before every shard, a worker receives the current
+// parameters, so every step uses the same weights as a
+// step on the full batch.
+// However, layers which depend on the whole batch only
+// see their own shard; in particular, BatchNorm layers
+// compute their statistics per shard rather than over the
+// full batch, so training is not exactly equivalent to
+// training with the full batch in one process.
+type Coordinator struct {
+	// Trainer computes the gradient of the first shard in
+	// this process.
+	Trainer *anyff.Trainer
+
+	// Params are all of the network's parameters, which
+	// are sent to the workers.
+	Params []*anydiff.Var
+
+	listener net.Listener
+	sockDir  string
+	workers  []*replicaConn
+	cmds     []*exec.Cmd
+	procs    sync.WaitGroup
+}
+
+type replicaConn struct {
+	conn net.Conn
+	enc  *gob.Encoder
+	dec  *gob.Decoder
+}
+
+type shardRequest struct {
+	Params  [][]float32
+	Inputs  []float32
+	Outputs []float32
+	Num     int
+}
+
+type shardResponse struct {
+	Grad [][]float32
+	Cost float64
+	Err  string
+}
+
+// StartReplicas launches numReplicas-1 worker processes
+// and waits for them to connect.
+//
+// Each worker runs this program with the same arguments
+// plus a -worker flag, and its thread count is limited to
+// workerThreads.
+// The network is either "unix" (a Unix socket in a
+// temporary directory) or "tcp" (a loopback port).
+func StartReplicas(numReplicas, workerThreads int, network string, t *anyff.Trainer,
+	params []*anydiff.Var) (*Coordinator, error) {
+	coord := &Coordinator{Trainer: t, Params: params}
+	var err error
+	switch network {
+	case "unix":
+		coord.sockDir, err = ioutil.TempDir("", "imagenet-train")
+		if err != nil {
+			return nil, essentials.AddCtx("start replicas", err)
+		}
+		coord.listener, err = net.Listen("unix", filepath.Join(coord.sockDir, "sock"))
+	case "tcp":
+		coord.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	default:
+		return nil, errors.New("start replicas: unknown network: " + network)
+	}
+	if err != nil {
+		coord.Close()
+		return nil, essentials.AddCtx("start replicas", err)
+	}
+	addr := network + ":" + coord.listener.Addr().String()
+
+	exited := make(chan error, numReplicas)
+	for i := 1; i < numReplicas; i++ {
+		args := append(append([]string{}, os.Args[1:]...), "-worker", addr,
+			"-threads", strconv.Itoa(workerThreads))
+		cmd := exec.Command(os.Args[0], args...)
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		if err := cmd.Start(); err != nil {
+			coord.kill()
+			return nil, essentials.AddCtx("start replicas", err)
+		}
+		coord.cmds = append(coord.cmds, cmd)
+		coord.procs.Add(1)
+		go func() {
+			exited <- cmd.Wait()
+			coord.procs.Done()
+		}()
+	}
+
+	accepted := make(chan net.Conn)
+	acceptErr := make(chan error, 1)
+	go func() {
+		for i := 1; i < numReplicas; i++ {
+			conn, err := coord.listener.Accept()
+			if err != nil {
+				acceptErr <- err
+				return
+			}
+			accepted <- conn
+		}
+	}()
+	for len(coord.workers) < numReplicas-1 {
+		select {
+		case conn := <-accepted:
+			coord.workers = append(coord.workers, &replicaConn{
+				conn: conn,
+				enc:  gob.NewEncoder(conn),
+				dec:  gob.NewDecoder(conn),
+			})
+		case err := <-acceptErr:
+			coord.kill()
+			return nil, essentials.AddCtx("start replicas", err)
+		case err := <-exited:
+			coord.kill()
+			return nil, fmt.Errorf("start replicas: worker exited early: %v", err)
+		}
+	}
+	return coord, nil
+}
+
+// Gradient computes the average gradient of the batch.
+//
+// If a worker fails, its shard is computed locally and
+// the worker is not used again.
+func (c *Coordinator) Gradient(b anysgd.Batch) anydiff.Grad {
+	batch := b.(*anyff.Batch)
+	shards := splitBatch(batch, len(c.workers)+1)
+
+	var params [][]float32
+	if len(shards) > 1 {
+		for _, p := range c.Params {
+			params = append(params, p.Vector.Data().([]float32))
+		}
+	}
+
+	responses := make([]*shardResponse, len(shards))
+	var wg sync.WaitGroup
+	for i := 1; i < len(shards); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i] = c.workers[i-1].compute(params, shards[i])
+		}(i)
+	}
+	grad := c.Trainer.Gradient(shards[0])
+	grad.Scale(scalar(grad, float64(shards[0].Num)/float64(batch.Num)))
+	cost := numToFloat(c.Trainer.LastCost) * float64(shards[0].Num)
+	wg.Wait()
+
+	var failed []int
+	for i := 1; i < len(shards); i++ {
+		res := responses[i]
+		if res.Err != "" {
+			log.Println("Worker failed:", res.Err)
+			failed = append(failed, i-1)
+			res = c.computeLocally(shards[i])
+		}
+		for j, p := range c.Trainer.Params {
+			vec := p.Vector.Creator().MakeVectorData(res.Grad[j])
+			vec.Scale(vec.Creator().MakeNumeric(float64(shards[i].Num) /
+				float64(batch.Num)))
+			grad[p].Add(vec)
+		}
+		cost += res.Cost * float64(shards[i].Num)
+	}
+	for i := len(failed) - 1; i >= 0; i-- {
+		c.workers[failed[i]].conn.Close()
+		c.workers = append(c.workers[:failed[i]], c.workers[failed[i]+1:]...)
+	}
+
+	c.Trainer.LastCost = cost / float64(batch.Num)
+	return grad
+}
+
+// NumReplicas returns the number of processes which are
+// computing gradients, including this one.
+func (c *Coordinator) NumReplicas() int {
+	return len(c.workers) + 1
+}
+
+// Close shuts down the workers.
+func (c *Coordinator) Close() {
+	for _, w := range c.workers {
+		w.conn.Close()
+	}
+	if c.listener != nil {
+		c.listener.Close()
+	}
+	c.procs.Wait()
+	if c.sockDir != "" {
+		os.RemoveAll(c.sockDir)
+	}
+}
+
+// kill stops the workers without waiting for them to
+// finish their work.
+func (c *Coordinator) kill() {
+	for _, cmd := range c.cmds {
+		cmd.Process.Kill()
+	}
+	c.Close()
+}
+
+func (c *Coordinator) computeLocally(b *anyff.Batch) *shardResponse {
+	grad := c.Trainer.Gradient(b)
+	res := &shardResponse{Cost: numToFloat(c.Trainer.LastCost)}
+	for _, p := range c.Trainer.Params {
+		res.Grad = append(res.Grad, grad[p].Data().([]float32))
+	}
+	return res
+}
+
+func (r *replicaConn) compute(params [][]float32, b *anyff.Batch) *shardResponse {
+	req := &shardRequest{
+		Params:  params,
+		Inputs:  b.Inputs.Output().Data().([]float32),
+		Outputs: b.Outputs.Output().Data().([]float32),
+		Num:     b.Num,
+	}
+	if err := r.enc.Encode(req); err != nil {
+		return &shardResponse{Err: err.Error()}
+	}
+	var res shardResponse
+	if err := r.dec.Decode(&res); err != nil {
+		return &shardResponse{Err: err.Error()}
+	}
+	return &res
+}
+
+// RunWorker connects to a Coordinator and computes
+// gradients until the connection is closed.
+//
+// The params must be the same parameters, in the same
+// order, as the Coordinator's Params.
+func RunWorker(addr string, t *anyff.Trainer, params []*anydiff.Var) error {
+	parts := strings.SplitN(addr, ":", 2)
+	if len(parts) != 2 {
+		return errors.New("run worker: bad address: " + addr)
+	}
+	conn, err := net.Dial(parts[0], parts[1])
+	if err != nil {
+		return essentials.AddCtx("run worker", err)
+	}
+	defer conn.Close()
+	enc := gob.NewEncoder(conn)
+	dec := gob.NewDecoder(conn)
+	for {
+		var req shardRequest
+		if err := dec.Decode(&req); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return essentials.AddCtx("run worker", err)
+		}
+		res := computeShard(t, params, &req)
+		if err := enc.Encode(res); err != nil {
+			return essentials.AddCtx("run worker", err)
+		}
+	}
+}
+
+func computeShard(t *anyff.Trainer, params []*anydiff.Var,
+	req *shardRequest) *shardResponse {
+	if len(req.Params) != len(params) {
+		return &shardResponse{Err: "parameter count mismatch"}
+	}
+	for i, p := range params {
+		if len(req.Params[i]) != p.Vector.Len() {
+			return &shardResponse{Err: "parameter size mismatch"}
+		}
+		p.Vector.SetData(req.Params[i])
+	}
+	c := params[0].Vector.Creator()
+	batch := &anyff.Batch{
+		Inputs:  anydiff.NewConst(c.MakeVectorData(req.Inputs)),
+		Outputs: anydiff.NewConst(c.MakeVectorData(req.Outputs)),
+		Num:     req.Num,
+	}
+	grad := t.Gradient(batch)
+	res := &shardResponse{Cost: numToFloat(t.LastCost)}
+	for _, p := range t.Params {
+		res.Grad = append(res.Grad, grad[p].Data().([]float32))
+	}
+	return res
+}
+
+// splitBatch splits a batch into at most n non-empty
+// shards of nearly equal size.
+func splitBatch(b *anyff.Batch, n int) []*anyff.Batch {
+	if n > b.Num {
+		n = b.Num
+	}
+	if n <= 1 {
+		return []*anyff.Batch{b}
+	}
+	inputs := b.Inputs.Output()
+	outputs := b.Outputs.Output()
+	inSize := inputs.Len() / b.Num
+	outSize := outputs.Len() / b.Num
+	var res []*anyff.Batch
+	var start int
+	for i := 0; i < n; i++ {
+		end := start + (b.Num-start)/(n-i)
+		res = append(res, &anyff.Batch{
+			Inputs:  anydiff.NewConst(inputs.Slice(start*inSize, end*inSize)),
+			Outputs: anydiff.NewConst(outputs.Slice(start*outSize, end*outSize)),
+			Num:     end - start,
+		})
+		start = end
+	}
+	return res
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/unixpickle/anydiff"
+	"github.com/unixpickle/anynet"
+	"github.com/unixpickle/anynet/anyconv"
+	"github.com/unixpickle/imagenet"
+)
+
+// Kinds of parameters, as used in ParamInfo.
+const (
+	WeightParam = "weight"
+	BiasParam   = "bias"
+	NormParam   = "norm"
+)
+
+// A ParamInfo describes a parameter of a network.
+type ParamInfo struct {
+	Var *anydiff.Var
+
+	// Kind is WeightParam, BiasParam, or NormParam (for
+	// BatchNorm scales and biases).
+	Kind string
+
+	// Layer is the index of the top-level layer which
+	// contains the parameter.
+	Layer int
+}
+
+// NetworkParams lists and classifies the parameters of a
+// network.
+//
+// Parameters of unrecognized layers are treated as
+// weights.
+func NetworkParams(net anynet.Net) []*ParamInfo {
+	var res []*ParamInfo
+	for i, layer := range net {
+		res = appendLayerParams(res, layer, i)
+	}
+	return res
+}
+
+func appendLayerParams(res []*ParamInfo, layer anynet.Layer, idx int) []*ParamInfo {
+	add := func(kind string, vars ...*anydiff.Var) {
+		for _, v := range vars {
+			if v != nil {
+				res = append(res, &ParamInfo{Var: v, Kind: kind, Layer: idx})
+			}
+		}
+	}
+	switch layer := layer.(type) {
+	case anynet.Net:
+		for _, sub := range layer {
+			res = appendLayerParams(res, sub, idx)
+		}
+	case *anyconv.Residual:
+		res = appendLayerParams(res, layer.Layer, idx)
+		if layer.Projection != nil {
+			res = appendLayerParams(res, layer.Projection, idx)
+		}
+	case *anyconv.BatchNorm:
+		add(NormParam, layer.Scalers, layer.Biases)
+	case *anyconv.Conv:
+		add(WeightParam, layer.Filters)
+		add(BiasParam, layer.Biases)
+	case *imagenet.DepthwiseConv:
+		add(WeightParam, layer.Filters)
+		add(BiasParam, layer.Biases)
+	case *anynet.FC:
+		add(WeightParam, layer.Weights)
+		add(BiasParam, layer.Biases)
+	case anynet.Parameterizer:
+		add(WeightParam, layer.Parameters()...)
+	}
+	return res
+}
+
+// FrozenParams finds the parameters of all but the last
+// trainLayers top-level layers.
+// If trainLayers is 0, no parameters are frozen.
+func FrozenParams(params []*ParamInfo, numLayers, trainLayers int) map[*anydiff.Var]bool {
+	res := map[*anydiff.Var]bool{}
+	if trainLayers == 0 {
+		return res
+	}
+	for _, p := range params {
+		if p.Layer < numLayers-trainLayers {
+			res[p.Var] = true
+		}
+	}
+	return res
+}
+
+// withoutParams filters out the parameters in a set.
+func withoutParams(params []*anydiff.Var, exclude map[*anydiff.Var]bool) []*anydiff.Var {
+	var res []*anydiff.Var
+	for _, p := range params {
+		if !exclude[p] {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// A ParamRule assigns a value to every parameter matched
+// by a selector.
+//
+// A selector is a parameter kind (e.g. "bias"), a layer
+// index (e.g. "3"), a range of layer indices (e.g.
+// "0-10"), or "*" for all parameters.
+type ParamRule struct {
+	Selector string
+	Value    float64
+}
+
+// ParseParamRules parses a comma-separated list of rules
+// of the form "selector=value".
+func ParseParamRules(spec string) ([]ParamRule, error) {
+	var res []ParamRule
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		parts := strings.Split(field, "=")
+		if len(parts) != 2 {
+			return nil, errors.New("bad parameter rule: " + field)
+		}
+		value, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return nil, errors.New("bad parameter rule: " + field)
+		}
+		rule := ParamRule{Selector: strings.TrimSpace(parts[0]), Value: value}
+		if err := checkSelector(rule.Selector); err != nil {
+			return nil, err
+		}
+		res = append(res, rule)
+	}
+	return res, nil
+}
+
+// ParseParamSelectors parses a comma-separated list of
+// selectors.
+func ParseParamSelectors(spec string) ([]string, error) {
+	var res []string
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if err := checkSelector(field); err != nil {
+			return nil, err
+		}
+		res = append(res, field)
+	}
+	return res, nil
+}
+
+// Matches checks if a selector applies to a parameter.
+func (p *ParamInfo) Matches(selector string) bool {
+	if selector == "*" || selector == p.Kind {
+		return true
+	}
+	min, max, ok := parseLayerRange(selector)
+	return ok && p.Layer >= min && p.Layer <= max
+}
+
+// ParamGroups stores per-parameter training settings.
+type ParamGroups struct {
+	// RateScales maps parameters to learning rate
+	// multipliers.
+	// Parameters which are not present use a multiplier
+	// of 1.
+	RateScales map[*anydiff.Var]float64
+
+	// DecayParams are the parameters to which weight decay
+	// applies.
+	DecayParams []*anydiff.Var
+}
+
+// NewParamGroups computes the settings for every
+// parameter.
+//
+// Learning rate rules are applied in order, so later
+// rules override earlier ones.
+// Parameters matching any of the noDecay selectors are
+// excluded from weight decay.
+func NewParamGroups(params []*ParamInfo, rateRules []ParamRule,
+	noDecay []string) *ParamGroups {
+	res := &ParamGroups{RateScales: map[*anydiff.Var]float64{}}
+	for _, p := range params {
+		for _, rule := range rateRules {
+			if p.Matches(rule.Selector) {
+				res.RateScales[p.Var] = rule.Value
+			}
+		}
+		decay := true
+		for _, selector := range noDecay {
+			if p.Matches(selector) {
+				decay = false
+			}
+		}
+		if decay {
+			res.DecayParams = append(res.DecayParams, p.Var)
+		}
+	}
+	return res
+}
+
+// RateScale gets the learning rate multiplier for a
+// parameter.
+func (p *ParamGroups) RateScale(v *anydiff.Var) float64 {
+	if scale, ok := p.RateScales[v]; ok {
+		return scale
+	}
+	return 1
+}
+
+func checkSelector(selector string) error {
+	switch selector {
+	case "*", WeightParam, BiasParam, NormParam:
+		return nil
+	}
+	if _, _, ok := parseLayerRange(selector); !ok {
+		return errors.New("bad parameter selector: " + selector)
+	}
+	return nil
+}
+
+func parseLayerRange(selector string) (min, max int, ok bool) {
+	parts := strings.Split(selector, "-")
+	if len(parts) > 2 {
+		return 0, 0, false
+	}
+	min, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	max = min
+	if len(parts) == 2 {
+		max, err = strconv.Atoi(parts[1])
+		if err != nil {
+			return 0, 0, false
+		}
+	}
+	return min, max, true
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// A ResizeSchedule implements progressive resizing, where
+// early epochs are trained at lower resolutions (and thus
+// more quickly) than later epochs.
+type ResizeSchedule struct {
+	// Sizes[i] is the input size used until epoch
+	// Epochs[i].
+	Sizes  []int
+	Epochs []int
+
+	// FinalSize is the input size after the last epoch in
+	// Epochs.
+	FinalSize int
+
+	// ScaleBatch, if set, increases the batch size for
+	// smaller inputs, keeping the number of pixels in each
+	// batch roughly constant.
+	ScaleBatch bool
+}
+
+// Validate checks that the schedule makes sense.
+func (r *ResizeSchedule) Validate() error {
+	if len(r.Sizes) != len(r.Epochs) {
+		return errors.New("resize schedule: need one epoch per size")
+	}
+	for i, size := range r.Sizes {
+		if size < 1 || size > r.FinalSize {
+			return fmt.Errorf("resize schedule: size %d must be between 1 and %d", size,
+				r.FinalSize)
+		}
+		if r.Epochs[i] < 1 || (i > 0 && r.Epochs[i] <= r.Epochs[i-1]) {
+			return errors.New("resize schedule: epochs must be positive and increasing")
+		}
+	}
+	return nil
+}
+
+// Size gets the input size for an epoch.
+func (r *ResizeSchedule) Size(epoch int) int {
+	for i, end := range r.Epochs {
+		if epoch < end {
+			return r.Sizes[i]
+		}
+	}
+	return r.FinalSize
+}
+
+// BatchSize gets the batch size for an epoch, given the
+// batch size at the final input size.
+func (r *ResizeSchedule) BatchSize(base, epoch int) int {
+	size := r.Size(epoch)
+	if !r.ScaleBatch || size == r.FinalSize {
+		return base
+	}
+	scale := float64(r.FinalSize*r.FinalSize) / float64(size*size)
+	return int(float64(base) * scale)
+}
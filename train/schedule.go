@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A Schedule is an anysgd.Rater which implements a
+// learning rate schedule.
+//
+// Schedules are saved with the training state, so the
+// schedule continues where it left off when training is
+// resumed.
+type Schedule struct {
+	// Kind is one of "const", "step", "multistep",
+	// "cosine", "exp", or "plateau".
+	Kind string
+
+	// Base is the initial learning rate.
+	Base float64
+
+	// Gamma is the factor by which the rate is multiplied
+	// for "step", "multistep", "exp", and "plateau".
+	// For "exp", the rate is multiplied by Gamma once per
+	// epoch, continuously.
+	Gamma float64
+
+	// StepEpochs is the number of epochs between decays
+	// for "step".
+	StepEpochs float64
+
+	// Milestones are the epochs at which to decay the rate
+	// for "multistep".
+	Milestones []float64
+
+	// TotalEpochs is the length of the cosine schedule.
+	TotalEpochs float64
+
+	// MinRate is the smallest learning rate for "cosine"
+	// and "plateau".
+	MinRate float64
+
+	// WarmupEpochs is the number of epochs over which the
+	// rate is linearly increased from 0, before the rest
+	// of the schedule takes effect.
+	WarmupEpochs float64
+
+	// Patience is the number of validation evaluations
+	// without improvement after which "plateau" decays the
+	// rate.
+	Patience int
+
+	// Plateau state, updated by Observe.
+	PlateauScale float64
+	PlateauBest  float64
+	PlateauBad   int
+}
+
+// Rate computes the learning rate for the given epoch.
+func (s *Schedule) Rate(epoch float64) float64 {
+	rate := s.Base
+	switch s.Kind {
+	case "step":
+		if s.StepEpochs > 0 {
+			rate *= math.Pow(s.Gamma, math.Floor(epoch/s.StepEpochs))
+		}
+	case "multistep":
+		for _, m := range s.Milestones {
+			if epoch >= m {
+				rate *= s.Gamma
+			}
+		}
+	case "cosine":
+		frac := math.Min(1, epoch/s.TotalEpochs)
+		rate = s.MinRate + (s.Base-s.MinRate)*(1+math.Cos(math.Pi*frac))/2
+	case "exp":
+		rate *= math.Pow(s.Gamma, epoch)
+	case "plateau":
+		if s.PlateauScale != 0 {
+			rate = math.Max(s.MinRate, rate*s.PlateauScale)
+		}
+	}
+	if s.WarmupEpochs > 0 && epoch < s.WarmupEpochs {
+		rate *= epoch / s.WarmupEpochs
+	}
+	return rate
+}
+
+// Observe reports a validation accuracy to the schedule.
+// It only has an effect for "plateau" schedules.
+//
+// It returns true if the rate was decayed.
+func (s *Schedule) Observe(accuracy float64) bool {
+	if s.Kind != "plateau" {
+		return false
+	}
+	if s.PlateauScale == 0 {
+		s.PlateauScale = 1
+	}
+	if accuracy > s.PlateauBest {
+		s.PlateauBest = accuracy
+		s.PlateauBad = 0
+		return false
+	}
+	s.PlateauBad++
+	if s.PlateauBad > s.Patience {
+		s.PlateauScale *= s.Gamma
+		s.PlateauBad = 0
+		return true
+	}
+	return false
+}
+
+// Validate checks that the schedule is well-formed.
+func (s *Schedule) Validate() error {
+	switch s.Kind {
+	case "const":
+	case "step":
+		if s.StepEpochs <= 0 {
+			return errors.New("step schedule requires a positive step interval")
+		}
+	case "multistep":
+		if len(s.Milestones) == 0 {
+			return errors.New("multistep schedule requires milestones")
+		}
+	case "cosine":
+		if s.TotalEpochs <= 0 {
+			return errors.New("cosine schedule requires a positive epoch count")
+		}
+	case "exp", "plateau":
+	default:
+		return errors.New("unknown schedule: " + s.Kind)
+	}
+	return nil
+}
+
+// parseMilestones parses a comma-separated list of epochs.
+func parseMilestones(str string) ([]float64, error) {
+	var res []float64
+	for _, field := range strings.Split(str, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		epoch, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return nil, errors.New("bad milestone: " + field)
+		}
+		res = append(res, epoch)
+	}
+	sort.Float64s(res)
+	return res, nil
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+
+	"github.com/unixpickle/anydiff"
+	"github.com/unixpickle/anynet/anyff"
+	"github.com/unixpickle/anynet/anysgd"
+	"github.com/unixpickle/anyvec"
+	"github.com/unixpickle/imagenet"
+)
+
+// errDone is returned by runEpoch when DoneFunc ends
+// training in the middle of an epoch.
+var errDone = errors.New("training done")
+
+// An SGD runs stochastic gradient descent, loading batches
+// in the background with an imagenet.Loader.
+//
+// It is like anysgd.SGD, except that it knows the order in
+// which samples will be used, allowing it to prefetch.
+//
+// Every random choice is derived from Seed, the epoch, and
+// the position within the epoch, so training can be
+// stopped and resumed without changing the outcome.
+type SGD struct {
+	Loader      *imagenet.Loader
+	Gradienter  anysgd.Gradienter
+	Transformer anysgd.Transformer
+	Samples     imagenet.SampleList
+	Rater       anysgd.Rater
+	StatusFunc  func(b anysgd.Batch)
+	BatchSize   int
+
+	// Groups, if non-nil, specifies per-parameter learning
+	// rate multipliers and which parameters are decayed by
+	// DecoupledDecay.
+	Groups *ParamGroups
+
+	// DecoupledDecay is the coefficient of weight decay
+	// applied directly to the parameters, independently of
+	// the gradient (as in AdamW).
+	// Each step, parameters are scaled by 1-rate*decay.
+	// It requires Groups.
+	DecoupledDecay float64
+
+	// Guard, if non-nil, clips gradients and handles
+	// non-finite steps.
+	Guard *Guard
+
+	// RateScale scales the learning rate from Rater.
+	// It is lowered by Guard when a step is non-finite.
+	// A value of 0 is treated as 1.
+	RateScale float64
+
+	// Resize, if non-nil, changes the input size and batch
+	// size at the start of each epoch.
+	// BatchSize is the batch size at the final input size.
+	Resize *ResizeSchedule
+
+	// ResizeFunc is called at the start of every epoch
+	// when Resize is set.
+	// It must prepare Loader and Gradienter for inputs of
+	// the given size.
+	ResizeFunc func(size, batchSize int) error
+
+	// BatchBatch is the number of mini-batches whose
+	// gradients are averaged for each step.
+	BatchBatch int
+
+	// StepFunc, if non-nil, is called after every update
+	// to the parameters.
+	// If it returns an error, training stops.
+	StepFunc func() error
+
+	// EpochFunc, if non-nil, is called at the end of every
+	// epoch.
+	EpochFunc func()
+
+	// DoneFunc, if non-nil, is called before every epoch
+	// and after every step.
+	// If it returns true, training stops without an error.
+	DoneFunc func() bool
+
+	Seed int64
+
+	// NumProcessed is the number of samples that have been
+	// used for training so far.
+	NumProcessed int
+
+	// Epoch is the index of the current epoch.
+	Epoch int
+
+	// Perm is the order of Samples for the current epoch.
+	// If it is nil, a new order is chosen.
+	Perm []int
+
+	// Position is the number of mini-batches from the
+	// current epoch that have been used.
+	Position int
+}
+
+// Run runs SGD until stop is closed, DoneFunc returns
+// true, or an error occurs.
+func (s *SGD) Run(stop <-chan struct{}) error {
+	for {
+		if s.done() {
+			return nil
+		}
+		if s.Perm == nil {
+			gen := rand.New(rand.NewSource(imagenet.MixSeed(s.Seed, int64(s.Epoch))))
+			s.Perm = gen.Perm(len(s.Samples))
+			s.Position = 0
+		}
+		epochSamples := make(imagenet.SampleList, len(s.Perm))
+		for i, j := range s.Perm {
+			epochSamples[i] = s.Samples[j]
+		}
+
+		if s.Resize != nil {
+			if err := s.ResizeFunc(s.Resize.Size(s.Epoch), s.batchSize()); err != nil {
+				return err
+			}
+		}
+
+		done := make(chan struct{})
+		epochSeed := imagenet.MixSeed(^s.Seed, int64(s.Epoch))
+		batches := s.Loader.Load(epochSamples, s.batchSize(), epochSeed, s.Position, done)
+		err := s.runEpoch(batches, stop)
+		close(done)
+		if err == errDone {
+			return nil
+		} else if err != nil || isStopped(stop) {
+			return err
+		}
+		if s.EpochFunc != nil {
+			s.EpochFunc()
+		}
+		s.Epoch++
+		s.Perm = nil
+	}
+}
+
+func (s *SGD) runEpoch(batches <-chan *imagenet.LoadedBatch, stop <-chan struct{}) error {
+	batchBatch := s.BatchBatch
+	if batchBatch < 1 {
+		batchBatch = 1
+	}
+	for !isStopped(stop) {
+		var grad anydiff.Grad
+		var lastBatch anysgd.Batch
+		var numSamples int
+		for i := 0; i < batchBatch; i++ {
+			loaded, ok := <-batches
+			if !ok {
+				return nil
+			} else if loaded.Err != nil {
+				return loaded.Err
+			}
+			g := s.Gradienter.Gradient(loaded.Batch)
+			if grad == nil {
+				grad = copyGrad(g)
+			} else {
+				for variable, vec := range g {
+					grad[variable].Add(vec)
+				}
+			}
+			lastBatch = loaded.Batch
+			numSamples += s.batchLen(loaded.Batch)
+		}
+		if batchBatch > 1 {
+			grad.Scale(scalar(grad, 1/float64(batchBatch)))
+		}
+
+		if s.StatusFunc != nil {
+			s.StatusFunc(lastBatch)
+		}
+
+		if s.Guard != nil {
+			if !s.Guard.Finite(grad) {
+				if err := s.handleNonFinite(); err != nil {
+					return err
+				}
+				s.NumProcessed += numSamples
+				s.Position += batchBatch
+				continue
+			}
+			s.Guard.Clip(grad)
+		}
+
+		if s.Transformer != nil {
+			grad = s.Transformer.Transform(grad)
+		}
+		rate := s.rate()
+		if s.Groups != nil {
+			s.applyGroups(grad, rate)
+		}
+		grad.Scale(scalar(grad, -rate))
+		grad.AddToVars()
+		s.NumProcessed += numSamples
+		s.Position += batchBatch
+
+		if s.StepFunc != nil {
+			if err := s.StepFunc(); err != nil {
+				return err
+			}
+		}
+		if s.done() {
+			return errDone
+		}
+	}
+	return nil
+}
+
+func (s *SGD) done() bool {
+	return s.DoneFunc != nil && s.DoneFunc()
+}
+
+// handleNonFinite responds to a non-finite step according
+// to s.Guard.
+func (s *SGD) handleNonFinite() error {
+	switch s.Guard.Response {
+	case SkipNonFinite:
+		log.Println("Skipping step with non-finite loss or gradient.")
+	case LowerRateNonFinite:
+		s.RateScale = s.rateScale() / 2
+		log.Println("Skipping step with non-finite loss or gradient;",
+			"scaling learning rate by", s.RateScale)
+	case RollbackNonFinite:
+		log.Println("Non-finite loss or gradient; rolling back.")
+		return ErrRollback
+	default:
+		return errors.New("non-finite loss or gradient")
+	}
+	return nil
+}
+
+// rate computes the current learning rate.
+func (s *SGD) rate() float64 {
+	return s.Rater.Rate(s.epochProgress()) * s.rateScale()
+}
+
+func (s *SGD) rateScale() float64 {
+	if s.RateScale == 0 {
+		return 1
+	}
+	return s.RateScale
+}
+
+// applyGroups scales the gradient for each parameter by
+// its learning rate multiplier and applies decoupled
+// weight decay.
+func (s *SGD) applyGroups(grad anydiff.Grad, rate float64) {
+	for variable, vec := range grad {
+		if scale := s.Groups.RateScale(variable); scale != 1 {
+			vec.Scale(vec.Creator().MakeNumeric(scale))
+		}
+	}
+	if s.DecoupledDecay == 0 {
+		return
+	}
+	for _, variable := range s.Groups.DecayParams {
+		if _, ok := grad[variable]; !ok {
+			continue
+		}
+		step := variable.Vector.Copy()
+		scale := -rate * s.DecoupledDecay * s.Groups.RateScale(variable)
+		step.Scale(step.Creator().MakeNumeric(scale))
+		variable.Vector.Add(step)
+	}
+}
+
+// batchSize gets the batch size for the current epoch.
+func (s *SGD) batchSize() int {
+	if s.Resize == nil {
+		return s.BatchSize
+	}
+	return s.Resize.BatchSize(s.BatchSize, s.Epoch)
+}
+
+// batchLen gets the number of samples in a batch, which
+// is less than the batch size if bad samples were skipped.
+func (s *SGD) batchLen(b anysgd.Batch) int {
+	if b, ok := b.(*anyff.Batch); ok {
+		return b.Num
+	}
+	return s.batchSize()
+}
+
+// epochProgress returns the fractional number of epochs
+// that have been completed.
+func (s *SGD) epochProgress() float64 {
+	return float64(s.NumProcessed) / float64(len(s.Samples))
+}
+
+func copyGrad(g anydiff.Grad) anydiff.Grad {
+	res := anydiff.Grad{}
+	for variable, vec := range g {
+		res[variable] = vec.Copy()
+	}
+	return res
+}
+
+// scalar creates a numeric compatible with the gradient.
+func scalar(g anydiff.Grad, x float64) anyvec.Numeric {
+	for _, vec := range g {
+		return vec.Creator().MakeNumeric(x)
+	}
+	return x
+}
+
+func isStopped(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"os"
+
+	"github.com/unixpickle/essentials"
+)
+
+// A TrainingState stores everything besides the network
+// that is needed to resume training exactly where it left
+// off.
+//
+// It is saved next to the network, with a ".state" suffix.
+type TrainingState struct {
+	// Seed is the root of every random choice made during
+	// training.
+	Seed int64
+
+	// Iter is the number of completed iterations.
+	Iter int
+
+	// NumProcessed is the number of training samples that
+	// have been used.
+	NumProcessed int
+
+	// Epoch is the index of the current epoch.
+	Epoch int
+
+	// Perm is the shuffled order of the training samples in
+	// the current epoch, and Position is the number of
+	// mini-batches of that order which have been used.
+	Perm     []int
+	Position int
+
+	// ValidationBatches is the index of the next validation
+	// batch, counting batches which were skipped because
+	// they failed to load.
+	ValidationBatches int
+
+	// BestAccuracy is the best top-1 accuracy from a full
+	// validation pass.
+	BestAccuracy float64
+
+	// WallTime is the number of seconds spent training.
+	WallTime float64
+
+	// RateScale is the factor by which the learning rate
+	// has been lowered due to non-finite steps.
+	// A value of 0 is treated as 1.
+	RateScale float64
+
+	Schedule  *Schedule
+	Optimizer *OptimizerState
+
+	// Stop is the state of the stop conditions.
+	Stop StopState
+
+	// EMA is the moving average of the weights, if one is
+	// being maintained.
+	EMA *EMAState
+}
+
+// StatePath returns the path of the training state file
+// for a network file.
+func StatePath(netPath string) string {
+	return netPath + ".state"
+}
+
+// LoadTrainingState loads the state for a network file.
+// If no state exists, it returns (nil, nil).
+func LoadTrainingState(netPath string) (*TrainingState, error) {
+	f, err := os.Open(StatePath(netPath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, essentials.AddCtx("load training state", err)
+	}
+	defer f.Close()
+	var res TrainingState
+	if err := gob.NewDecoder(f).Decode(&res); err != nil {
+		return nil, essentials.AddCtx("load training state", err)
+	}
+	return &res, nil
+}
+
+// Save saves the state for a network file.
+func (t *TrainingState) Save(netPath string) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(t); err != nil {
+		return essentials.AddCtx("save training state", err)
+	}
+	if err := writeFileAtomic(StatePath(netPath), buf.Bytes()); err != nil {
+		return essentials.AddCtx("save training state", err)
+	}
+	return nil
+}
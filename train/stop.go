@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// A Stopper decides when training is finished, so that
+// runs can end without anybody pressing ctrl+c.
+//
+// A zero limit disables the corresponding condition.
+type Stopper struct {
+	MaxEpochs int
+	MaxIters  int
+
+	// MaxTime is the wall-clock budget for training,
+	// including time spent before training was resumed.
+	MaxTime time.Duration
+
+	// TargetTop1 is the top-1 validation accuracy at which
+	// training is considered done.
+	TargetTop1 float64
+
+	// Patience is the number of full validation passes in
+	// a row which may fail to lower the validation loss by
+	// at least MinDelta before training stops.
+	// The validation loss does not include weight decay,
+	// so shrinking weights alone do not count as progress.
+	Patience int
+	MinDelta float64
+
+	// State is updated by Observe.
+	State StopState
+}
+
+// StopState is the validation loss history used for
+// patience, which is saved with the training state.
+type StopState struct {
+	Observed bool
+	BestLoss float64
+	Bad      int
+}
+
+// Validate checks that the limits make sense.
+func (s *Stopper) Validate() error {
+	if s.MaxEpochs < 0 || s.MaxIters < 0 || s.MaxTime < 0 || s.Patience < 0 ||
+		s.MinDelta < 0 {
+		return errors.New("stop conditions: limits must not be negative")
+	}
+	if s.TargetTop1 < 0 || s.TargetTop1 > 1 {
+		return errors.New("stop conditions: target accuracy must be between 0 and 1")
+	}
+	return nil
+}
+
+// Observe records the loss from a full validation pass.
+func (s *Stopper) Observe(loss float64) {
+	if !s.State.Observed || loss < s.State.BestLoss-s.MinDelta {
+		s.State = StopState{Observed: true, BestLoss: loss}
+		return
+	}
+	s.State.Bad++
+}
+
+// Reason returns the reason to stop training, or "" if
+// training should continue.
+//
+// The epoch is the index of the current epoch, so it is
+// the number of completed epochs when called between
+// epochs.
+// The wall time is in seconds, and bestTop1 is the best
+// accuracy from a full validation pass.
+func (s *Stopper) Reason(epoch, iter int, wallTime, bestTop1 float64) string {
+	switch {
+	case s.MaxEpochs > 0 && epoch >= s.MaxEpochs:
+		return fmt.Sprintf("reached %d epochs", s.MaxEpochs)
+	case s.MaxIters > 0 && iter >= s.MaxIters:
+		return fmt.Sprintf("reached %d iterations", s.MaxIters)
+	case s.MaxTime > 0 && wallTime >= s.MaxTime.Seconds():
+		return fmt.Sprintf("reached time limit of %v", s.MaxTime)
+	case s.TargetTop1 > 0 && bestTop1 >= s.TargetTop1:
+		return fmt.Sprintf("reached target accuracy of %v", s.TargetTop1)
+	case s.Patience > 0 && s.State.Bad >= s.Patience:
+		return fmt.Sprintf("validation loss has not improved in %d validations", s.State.Bad)
+	}
+	return ""
+}